@@ -0,0 +1,76 @@
+package ingester
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/google/uuid"
+)
+
+// appIDExtension carries the Convoy app on CloudEvents published to the
+// queue. Extension names are limited to lower-case letters and digits.
+const appIDExtension = "convoyappid"
+
+var ErrMissingAppID = errors.New("CloudEvent has no " + appIDExtension + " extension")
+
+// ToCloudEvent renders the request as a structured mode CloudEvent, with
+// the provider as source and the event type as type. id defaults to a
+// random UUID.
+func (c *convoyRequest) ToCloudEvent(source, id string, receivedAt time.Time) ([]byte, error) {
+	if len(id) == 0 {
+		id = uuid.New().String()
+	}
+
+	e := cloudevents.New()
+	e.SetID(id)
+	e.SetSource(source)
+	e.SetType(c.Data.Event)
+	e.SetTime(receivedAt)
+	e.SetExtension(appIDExtension, c.Data.AppID)
+
+	if err := e.SetData(cloudevents.ApplicationJSON, json.RawMessage(c.Data.Data)); err != nil {
+		return nil, err
+	}
+
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(e)
+}
+
+// FromCloudEvent reads a queued CloudEvent. The whole CloudEvent is pushed
+// to Convoy as the event's data.
+func (c *convoyRequest) FromCloudEvent(b []byte) error {
+	var e cloudevents.Event
+	if err := json.Unmarshal(b, &e); err != nil {
+		return err
+	}
+
+	appID, ok := e.Extensions()[appIDExtension].(string)
+	if !ok {
+		return ErrMissingAppID
+	}
+
+	c.Data.AppID = appID
+	c.Data.Event = e.Type()
+	c.Data.Data = b
+	c.IdempotencyKey = e.Source() + ":" + e.ID()
+
+	return nil
+}
+
+// isCloudEvent reports whether a queued message is a CloudEvent rather than
+// a convoyRequest.
+func isCloudEvent(b []byte) bool {
+	probe := struct {
+		SpecVersion string `json:"specversion"`
+	}{}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return false
+	}
+
+	return len(probe.SpecVersion) != 0
+}
@@ -0,0 +1,106 @@
+package ingester
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	convoyModels "github.com/frain-dev/convoy-go/models"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ConvoyRequest_CloudEvent(t *testing.T) {
+	// Arrange
+	req := &convoyRequest{
+		Data: convoyModels.EventRequest{
+			AppID: "app-id",
+			Event: "charge.success",
+			Data:  []byte(`{"id":302961}`),
+		},
+	}
+	receivedAt := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Act
+	b, err := req.ToCloudEvent("paystack", "evt_1", receivedAt)
+	require.NoError(t, err)
+
+	// Assert
+	var ce map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &ce))
+	require.Equal(t, map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              "evt_1",
+		"source":          "paystack",
+		"type":            "charge.success",
+		"time":            "2022-06-01T12:00:00Z",
+		"datacontenttype": "application/json",
+		"convoyappid":     "app-id",
+		"data":            map[string]interface{}{"id": float64(302961)},
+	}, ce)
+
+	require.True(t, isCloudEvent(b))
+
+	out := &convoyRequest{}
+	require.NoError(t, out.FromCloudEvent(b))
+	require.Equal(t, "app-id", out.Data.AppID)
+	require.Equal(t, "charge.success", out.Data.Event)
+	require.JSONEq(t, string(b), string(out.Data.Data))
+	require.Equal(t, "paystack:evt_1", out.IdempotencyKey)
+}
+
+func Test_IsCloudEvent(t *testing.T) {
+	req := &convoyRequest{
+		Data: convoyModels.EventRequest{
+			AppID: "app-id",
+			Event: "charge.success",
+			Data:  []byte(`{"specversion":"1.0"}`),
+		},
+	}
+
+	b, err := req.ToBytes()
+	require.NoError(t, err)
+	require.False(t, isCloudEvent(b))
+}
+
+func Test_WebhooksHandler_CloudEventIDs(t *testing.T) {
+	// Arrange
+	t.Setenv(CONFIG_ENV, `[{
+		"name": "orders",
+		"format": "cloudevents",
+		"verifier_config": {"type": "api_key", "header": "X-API-Key", "api_key": "orders-key"},
+		"routes": [{"app_ids": ["app-1", "app-2"]}],
+		"idempotency": {"path": "$.id"}
+	}]`)
+	require.NoError(t, LoadConfig(CONFIG_ENV))
+	require.NoError(t, LoadProviderStore())
+
+	srv, topic, _ := newTestSubscription(t, context.Background())
+
+	prevTopic, prevStore := publishTopic, dedupeStore
+	publishTopic, dedupeStore = topic, newMemoryDedupeStore()
+	defer func() { publishTopic, dedupeStore = prevTopic, prevStore }()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/webhooks/orders", strings.NewReader(`{"id":"ord_1"}`))
+	req.Header.Set("X-API-Key", "orders-key")
+	rec := httptest.NewRecorder()
+
+	// Act
+	WebhookEndpoint(rec, req)
+
+	// Assert: each app's copy has its own ID.
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var ids []string
+	for _, m := range srv.Messages() {
+		var e struct {
+			ID string `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal(m.Data, &e))
+		ids = append(ids, e.ID)
+	}
+	require.Equal(t, []string{"ord_1:app-1", "ord_1:app-2"}, ids)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	ingester "github.com/frain-dev/convoy-ingester"
+)
+
+const usage = `Usage: ingesterctl <command> [flags]
+
+Commands:
+  genkey    Generate a master key for encrypted config values
+  signkey   Generate an Ed25519 key pair for signing forwarded events
+  encrypt   Encrypt a secret for a provider config field
+  transform Run a provider's transform steps on a sample payload
+  script    Run a provider's verification and script on a sample request
+  deadletter
+            List, inspect, edit and replay dead-lettered events:
+              deadletter list [-dir <dir> | -subscription <sub>]
+              deadletter inspect -id <id>
+              deadletter edit -id <id> [-file <edited.json>]
+              deadletter replay -id <id> [-project <project>] [-topic <topic>]
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "genkey":
+		err = genKey()
+	case "signkey":
+		err = signKey()
+	case "encrypt":
+		err = encrypt(os.Args[2:])
+	case "transform":
+		err = transform(os.Args[2:])
+	case "script":
+		err = script(os.Args[2:])
+	case "deadletter":
+		err = deadLetter(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%s: %v\n", os.Args[1], err)
+	}
+}
+
+func genKey() error {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(key))
+	return nil
+}
+
+// signKey prints the private key to set as $CONVOY_INGESTER_SIGNING_KEY
+// and the public key to give consumers.
+func signKey() error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("private: %s\npublic:  %s\n",
+		base64.StdEncoding.EncodeToString(priv.Seed()), base64.StdEncoding.EncodeToString(pub))
+	return nil
+}
+
+// encrypt reads the secret from stdin so it never shows up in shell history.
+func encrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	provider := fs.String("provider", "", "provider name, e.g. paystack")
+	field := fs.String("field", "", "field path, e.g. verifier_config.secret")
+	fs.Parse(args)
+
+	if len(*provider) == 0 || len(*field) == 0 {
+		return fmt.Errorf("-provider and -field are required")
+	}
+
+	kw, err := ingester.NewKeyWrapperFromEnv(ingester.MASTER_KEY_ENV)
+	if err != nil {
+		return err
+	}
+
+	if kw == nil {
+		return fmt.Errorf("%s is not set", ingester.MASTER_KEY_ENV)
+	}
+
+	secret, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	value, err := ingester.EncryptConfigValue(kw, *provider, *field, []byte(strings.TrimRight(string(secret), "\r\n")))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(value)
+	return nil
+}
+
+// transform reads the sample payload from -payload or stdin.
+func transform(args []string) error {
+	fs := flag.NewFlagSet("transform", flag.ExitOnError)
+	config := fs.String("config", "", "provider config file, defaults to $"+ingester.CONFIG_ENV)
+	provider := fs.String("provider", "", "provider name, e.g. paystack")
+	payloadFile := fs.String("payload", "", "sample payload file")
+	fs.Parse(args)
+
+	p, err := loadProvider(*config, *provider)
+	if err != nil {
+		return err
+	}
+
+	payload, err := readInput(*payloadFile)
+	if err != nil {
+		return err
+	}
+
+	out, err := p.Transform(payload)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(out))
+	return nil
+}
+
+// headerFlags collects repeated -H "Name: value" flags.
+type headerFlags http.Header
+
+func (h headerFlags) String() string { return "" }
+
+func (h headerFlags) Set(v string) error {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid header %q", v)
+	}
+
+	http.Header(h).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return nil
+}
+
+// script runs the provider's verifier and script against a sample request
+// read from -payload or stdin.
+func script(args []string) error {
+	headers := headerFlags{}
+
+	fs := flag.NewFlagSet("script", flag.ExitOnError)
+	config := fs.String("config", "", "provider config file, defaults to $"+ingester.CONFIG_ENV)
+	provider := fs.String("provider", "", "provider name, e.g. paystack")
+	payloadFile := fs.String("payload", "", "sample payload file")
+	fs.Var(headers, "H", "request header, e.g. -H 'X-Signature: abc' (repeatable)")
+	fs.Parse(args)
+
+	p, err := loadProvider(*config, *provider)
+	if err != nil {
+		return err
+	}
+
+	payload, err := readInput(*payloadFile)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest(http.MethodPost, "/v1/webhooks/"+*provider, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	r.Header = http.Header(headers)
+
+	if err := p.VerifyRequest(r, payload); err != nil {
+		return err
+	}
+
+	result, err := p.RunScript(r, payload)
+	if err != nil {
+		return err
+	}
+
+	if result.Body != nil {
+		payload = result.Body
+	}
+
+	event := result.EventType
+	if len(event) == 0 {
+		event = p.EventType(r, payload)
+	}
+
+	fmt.Printf("event_type: %s\nbody: %s\n", event, payload)
+	return nil
+}
+
+// deadLetter manages the dead-letter directory in -dir, which defaults to
+// $CONVOY_INGESTER_DEAD_LETTER_DIR, or the events published to the
+// dead-letter topic, read from -subscription.
+func deadLetter(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing subcommand: list, inspect, edit or replay")
+	}
+
+	fs := flag.NewFlagSet("deadletter "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", os.Getenv(ingester.DEAD_LETTER_DIR_ENV), "dead-letter directory")
+	subscription := fs.String("subscription", "", "subscription to the dead-letter topic, used instead of -dir")
+	id := fs.String("id", "", "dead-lettered event ID")
+	file := fs.String("file", "", "edited event file, defaults to stdin")
+	project := fs.String("project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "Google Cloud project")
+	topic := fs.String("topic", os.Getenv("WEBHOOK_TOPIC"), "topic PushToConvoy is subscribed to")
+	fs.Parse(args[1:])
+
+	ctx := context.Background()
+	var client *pubsub.Client
+	if len(*subscription) != 0 || args[0] == "replay" {
+		var err error
+		client, err = pubsub.NewClient(ctx, *project)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+	}
+
+	var store ingester.DeadLetterStore
+	switch {
+	case len(*subscription) != 0:
+		sub := client.Subscription(*subscription)
+		cfg, err := sub.Config(ctx)
+		if err != nil {
+			return err
+		}
+
+		store = ingester.NewSubscriptionDeadLetterStore(sub, cfg.Topic)
+
+	case len(*dir) != 0:
+		var err error
+		store, err = ingester.NewDirectoryDeadLetterStore(*dir)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("-dir, $%s or -subscription is required", ingester.DEAD_LETTER_DIR_ENV)
+	}
+
+	if args[0] == "list" {
+		letters, err := store.List()
+		if err != nil {
+			return err
+		}
+
+		for _, d := range letters {
+			fmt.Printf("%s\t%s\t%s\t%d attempts\t%s\n", d.ID, d.CreatedAt.Format(time.RFC3339),
+				d.Attributes["provider"], len(d.Attempts), d.Error)
+		}
+		return nil
+	}
+
+	if len(*id) == 0 {
+		return fmt.Errorf("-id is required")
+	}
+
+	d, err := store.Get(*id)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "inspect":
+		b, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(b))
+		return nil
+
+	// edit replaces the event with the edited output of inspect.
+	case "edit":
+		b, err := readInput(*file)
+		if err != nil {
+			return err
+		}
+
+		edited := &ingester.DeadLetter{}
+		if err := json.Unmarshal(b, edited); err != nil {
+			return err
+		}
+		edited.ID = d.ID
+
+		return store.Put(edited)
+
+	// replay publishes the event for PushToConvoy again and removes it.
+	case "replay":
+		msgID, err := d.Replay(ctx, client.Topic(*topic))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Replayed %s as message %s\n", d.ID, msgID)
+		return store.Delete(d.ID)
+
+	default:
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
+}
+
+// loadProvider loads the provider store from the config file, or from the
+// environment when no file is given.
+func loadProvider(config, name string) (*ingester.Provider, error) {
+	if len(config) != 0 {
+		b, err := ioutil.ReadFile(config)
+		if err != nil {
+			return nil, err
+		}
+		os.Setenv(ingester.CONFIG_ENV, string(b))
+	}
+
+	kw, err := ingester.NewKeyWrapperFromEnv(ingester.MASTER_KEY_ENV)
+	if err != nil {
+		return nil, err
+	}
+	ingester.SetKeyWrapper(kw)
+
+	if err := ingester.LoadConfig(ingester.CONFIG_ENV); err != nil {
+		return nil, err
+	}
+
+	if err := ingester.LoadProviderStore(); err != nil {
+		return nil, err
+	}
+
+	return ingester.LookupProvider(name)
+}
+
+func readInput(file string) ([]byte, error) {
+	if len(file) == 0 {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	return ioutil.ReadFile(file)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"cloud.google.com/go/pubsub"
+	ingester "github.com/frain-dev/convoy-ingester"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	subscription := os.Getenv("WORKER_SUBSCRIPTION")
+	if len(subscription) == 0 {
+		log.Fatalf("WORKER_SUBSCRIPTION is not set")
+	}
+
+	config, err := ingester.LoadWorkerConfig()
+	if err != nil {
+		log.Fatalf("LoadWorkerConfig: %v\n", err)
+	}
+
+	client, err := pubsub.NewClient(ctx, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if err != nil {
+		log.Fatalf("pubsub.NewClient: %v\n", err)
+	}
+	defer client.Close()
+
+	if err := ingester.RunWorker(ctx, client.Subscription(subscription), config); err != nil {
+		log.Fatalf("RunWorker: %v\n", err)
+	}
+}
@@ -3,16 +3,220 @@ package ingester
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Configuration []ProviderConfig
 
 type ProviderConfig struct {
 	Name           string         `json:"name"`
+	Preset         string         `json:"preset"`
 	AppID          string         `json:"app_id"`
 	VerifierConfig VerifierConfig `json:"verifier_config"`
+
+	EventType *EventTypeConfig `json:"event_type"`
+	Routes    []RouteConfig    `json:"routes"`
+	Filters   *FilterConfig    `json:"filters"`
+
+	Idempotency *IdempotencyConfig `json:"idempotency"`
+	Handshake   *HandshakeConfig   `json:"handshake"`
+
+	Transform []TransformConfig `json:"transform"`
+	Envelope  *EnvelopeConfig   `json:"envelope"`
+	Script    *ScriptConfig     `json:"script"`
+
+	// Format of queued events: "convoy" (default) or "cloudevents".
+	Format string `json:"format"`
+
+	// OrderingKey is the Pub/Sub ordering key of the provider's events. It
+	// may reference {provider}, {event}, {app_id}, {header:<Name>} and
+	// {path:<JSON path>}, e.g. "{provider}:{path:$.data.customer.id}".
+	OrderingKey string `json:"ordering_key"`
+
+	// Convoy is the tenant the provider's events are pushed to.
+	Convoy *ConvoyTargetConfig `json:"convoy"`
+
+	// HTTP delivers the provider's events to any HTTP endpoint instead of
+	// Convoy.
+	HTTP *HTTPTargetConfig `json:"http"`
+}
+
+// HTTPTargetConfig relays events to an HTTP endpoint. Method defaults to
+// POST. Header values may reference {provider}, {event}, {app_id},
+// {idempotency_key}, {request_id} and {env:<NAME>}. Timeout defaults to
+// 10s.
+//
+// With Batch set, the worker sends a JSON array of events in one request.
+// Headers only render the fields every event of the batch shares, and
+// leave the rest empty.
+type HTTPTargetConfig struct {
+	URL       string               `json:"url"`
+	Method    string               `json:"method"`
+	Headers   map[string]string    `json:"headers"`
+	Timeout   string               `json:"timeout"`
+	Signature *HTTPSignatureConfig `json:"signature"`
+	Batch     bool                 `json:"batch"`
+}
+
+// HTTPSignatureConfig signs the relayed body with HMAC, so the endpoint
+// can check it came from the ingester. Header defaults to
+// X-Relay-Signature, Hash to SHA256 and Encoding to hex.
+type HTTPSignatureConfig struct {
+	Header   string `json:"header"`
+	Hash     string `json:"hash"`
+	Encoding string `json:"encoding"`
+	Secret   string `json:"secret"`
+}
+
+// ConvoyConfig is the Convoy API events are pushed to. It is read from the
+// environment once per instance.
+type ConvoyConfig struct {
+	URL       string
+	GroupID   string
+	ProjectID string
+	APIKey    string
+	Username  string
+	Password  string
+
+	// Timeout of each request to Convoy.
+	Timeout time.Duration
+
+	// MaxIdleConns is how many keep-alive connections to Convoy are kept
+	// open.
+	MaxIdleConns int
+
+	// RateLimit is the events per second forwarded to each target, with
+	// bursts of up to RateBurst. It is off when zero.
+	RateLimit float64
+	RateBurst int
+
+	// BreakerThreshold is how many consecutive failures open a target's
+	// circuit, for BreakerCooldown. It is off when zero.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// ConvoyTargetConfig is the Convoy tenant a provider's events are pushed
+// to. Unset fields fall back to the ConvoyConfig.
+//
+// The target is read from the provider's config by PushToConvoy, never
+// from the queued event, so PushToConvoy needs the config too. APIKeyEnv
+// names the environment variable of PushToConvoy holding the key, and
+// must start with CONVOY_.
+//
+// API selects how events are created: "apps" (default) for Convoy's
+// legacy apps API, "endpoint" or "fanout" for the project-scoped events
+// API, with the app ID as the endpoint or owner ID, or "ingest" to send
+// the payload to the incoming source with SourceMaskID.
+//
+// Timeout overrides the ConvoyConfig's timeout of each request.
+type ConvoyTargetConfig struct {
+	URL       string `json:"url"`
+	GroupID   string `json:"group_id"`
+	ProjectID string `json:"project_id"`
+	APIKeyEnv string `json:"api_key_env"`
+	APIKey    string `json:"api_key"`
+
+	API          string `json:"api"`
+	SourceMaskID string `json:"source_mask_id"`
+	Timeout      string `json:"timeout"`
+}
+
+// EnvelopeConfig wraps the payload with metadata of the inbound request.
+// Headers lists the headers to include, or "*" for all of them. Credential
+// headers are redacted, along with any listed in Redact.
+type EnvelopeConfig struct {
+	Headers  []string `json:"headers"`
+	Redact   []string `json:"redact"`
+	Query    bool     `json:"query"`
+	SourceIP bool     `json:"source_ip"`
+}
+
+// ScriptConfig is a Starlark script run after verification. Source holds
+// the script inline, File points to it on disk. Verify declares that the
+// script verifies webhooks itself, which providers without a verifier
+// must, or their webhooks are rejected.
+type ScriptConfig struct {
+	Source   string `json:"source"`
+	File     string `json:"file"`
+	MaxSteps uint64 `json:"max_steps"`
+	Timeout  string `json:"timeout"`
+	Verify   bool   `json:"verify"`
+}
+
+// TransformConfig is a single step of a provider's transform pipeline.
+//
+//	remove:   delete Path
+//	rename:   move Path to To
+//	set:      set Path to Value, or to the rendered Template
+//	wrap:     nest the payload under Key
+//	template: replace the payload with the rendered Template, which must be JSON
+//
+// Templates use Go's text/template with the payload as dot.
+type TransformConfig struct {
+	Op       string      `json:"op"`
+	Path     string      `json:"path"`
+	To       string      `json:"to"`
+	Key      string      `json:"key"`
+	Value    interface{} `json:"value"`
+	Template string      `json:"template"`
+}
+
+// EventTypeConfig describes how to derive the Convoy event type from a
+// webhook. Header takes precedence over Path. Template and Fallback may
+// reference {provider} and {event}.
+type EventTypeConfig struct {
+	Header   string `json:"header"`
+	Path     string `json:"path"`
+	Template string `json:"template"`
+	Fallback string `json:"fallback"`
+}
+
+// RouteConfig sends webhooks matching Match to each app in AppIDs. All
+// matching routes receive the event; AppID is used when none match.
+type RouteConfig struct {
+	AppIDs []string    `json:"app_ids"`
+	Match  MatchConfig `json:"match"`
+}
+
+// IdempotencyConfig locates the key providers reuse when retrying a
+// delivery. Header takes precedence over Path. TTL defaults to 24h.
+type IdempotencyConfig struct {
+	Header string `json:"header"`
+	Path   string `json:"path"`
+	TTL    string `json:"ttl"`
+}
+
+// HandshakeConfig selects how subscription challenges are answered: slack,
+// meta, msgraph or zoom. VerifyToken is the token registered with Meta.
+// Secret signs Zoom's response and defaults to the hmac verifier's secret.
+type HandshakeConfig struct {
+	Type        string `json:"type"`
+	VerifyToken string `json:"verify_token"`
+	Secret      string `json:"secret"`
+}
+
+// FilterConfig drops unwanted webhooks. When Allow is set a webhook must
+// match one of its rules, and a webhook matching any Deny rule is dropped.
+type FilterConfig struct {
+	Allow []MatchConfig `json:"allow"`
+	Deny  []MatchConfig `json:"deny"`
+}
+
+// MatchConfig selects webhooks. Every condition set must hold.
+type MatchConfig struct {
+	// EventTypes are glob patterns, e.g. "charge.*".
+	EventTypes []string `json:"event_types"`
+
+	// Headers maps header names to their expected values.
+	Headers map[string]string `json:"headers"`
+
+	// Fields maps JSON paths in the body to their expected values.
+	Fields map[string]string `json:"fields"`
 }
 
 type VerifierConfig struct {
@@ -20,12 +224,46 @@ type VerifierConfig struct {
 	*BasicAuthConfig
 	*APIKeyConfig
 	*IPAddressConfig
+	*SNSConfig
 }
 
 type HmacConfig struct {
 	Header string `json:"header"`
 	Hash   string `json:"hash"`
 	Secret string `json:"secret"`
+
+	// Encoding of the signature in the header, hex or base64. Defaults to hex.
+	Encoding string `json:"encoding"`
+
+	// Prefix precedes the signature in the header, e.g. "sha256=".
+	Prefix string `json:"prefix"`
+
+	// SignedPayload is the content the provider signs. It may reference
+	// {body}, {header:<Name>}, {url}, {form} and {param:<key>}, and
+	// defaults to the raw body. {form} is the form parameters sorted by
+	// name, each name followed by its values.
+	SignedPayload string `json:"signed_payload"`
+
+	// SignatureKey reads the signature from a header of comma-separated
+	// key=value pairs, e.g. "v1" for Stripe's "t=...,v1=...". Any value
+	// of the key may match, so secrets can be rolled. {param:<key>} is the
+	// header's value for another key.
+	SignatureKey string `json:"signature_key"`
+
+	// Timestamp is the Unix time the provider signed at, e.g.
+	// "{header:X-Slack-Request-Timestamp}", taking the same placeholders
+	// as SignedPayload. Requests signed more than Tolerance (default 5m)
+	// from now are rejected, so they can't be replayed.
+	Timestamp string `json:"timestamp"`
+	Tolerance string `json:"tolerance"`
+
+	// PublicURL is the scheme, host and any path prefix the provider
+	// calls, used to rebuild {url} behind a proxy.
+	PublicURL string `json:"public_url"`
+
+	// ForwardedHeaders rebuilds {url} from X-Forwarded-Proto and
+	// X-Forwarded-Host when PublicURL isn't set.
+	ForwardedHeaders bool `json:"forwarded_headers"`
 }
 
 type BasicAuthConfig struct {
@@ -42,6 +280,42 @@ type IPAddressConfig struct {
 	IPSafelist []string `json:"ip_safelist"`
 }
 
+// SNSConfig verifies AWS SNS messages. CertHosts are glob patterns for the
+// hosts certificates and subscribe URLs may be served from, matched label by
+// label, and default to SNS's own. TopicARNs, when set, restricts the topics accepted.
+type SNSConfig struct {
+	CertHosts   []string `json:"cert_hosts"`
+	TopicARNs   []string `json:"topic_arns"`
+	AutoConfirm bool     `json:"auto_confirm"`
+}
+
+func (pC *ProviderConfig) UnmarshalJSON(data []byte) error {
+	temp := struct {
+		Preset string `json:"preset"`
+	}{}
+	if err := json.Unmarshal(data, &temp); err != nil {
+		return err
+	}
+
+	if len(temp.Preset) != 0 {
+		var err error
+		data, err = applyPreset(temp.Preset, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	// providerConfig drops this method to avoid recursing.
+	type providerConfig ProviderConfig
+	var c providerConfig
+	if err := unmarshalJSON(data, &c); err != nil {
+		return err
+	}
+
+	*pC = ProviderConfig(c)
+	return nil
+}
+
 func (vC *VerifierConfig) UnmarshalJSON(data []byte) error {
 	temp := struct {
 		Type string `json:"type"`
@@ -55,6 +329,7 @@ func (vC *VerifierConfig) UnmarshalJSON(data []byte) error {
 	vC.APIKeyConfig = nil
 	vC.BasicAuthConfig = nil
 	vC.IPAddressConfig = nil
+	vC.SNSConfig = nil
 
 	switch temp.Type {
 	case "hmac":
@@ -89,6 +364,14 @@ func (vC *VerifierConfig) UnmarshalJSON(data []byte) error {
 
 		vC.IPAddressConfig = &c
 		return nil
+	case "sns":
+		var c SNSConfig
+		if err := json.Unmarshal(data, &c); err != nil {
+			return err
+		}
+
+		vC.SNSConfig = &c
+		return nil
 	default:
 		//TODO(subomi): rewrite this to an error type
 		return errors.New("Invalid verification config")
@@ -101,10 +384,70 @@ func LoadConfig(env string) error {
 		return errors.New("Configuration cannot be empty")
 	}
 
-	s := strings.NewReader(f)
-	if err := json.NewDecoder(s).Decode(&configStore); err != nil {
+	data, err := decryptConfig(keyWrapper, []byte(f))
+	if err != nil {
+		return err
+	}
+
+	if err := unmarshalJSON(data, &configStore); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// LoadConvoyConfig reads the Convoy API configuration from the environment.
+func LoadConvoyConfig() (*ConvoyConfig, error) {
+	c := &ConvoyConfig{
+		URL:          os.Getenv(CONVOY_URL_ENV),
+		GroupID:      os.Getenv(CONVOY_GROUP_ID_ENV),
+		APIKey:       os.Getenv(CONVOY_API_KEY_ENV),
+		Username:     os.Getenv(CONVOY_API_USERNAME_ENV),
+		Password:     os.Getenv(CONVOY_API_PASSWORD_ENV),
+		Timeout:      defaultConvoyTimeout,
+		MaxIdleConns: defaultConvoyMaxIdleConns,
+
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
+	}
+
+	if v := os.Getenv(CONVOY_TIMEOUT_ENV); len(v) != 0 {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", CONVOY_TIMEOUT_ENV, err)
+		}
+		c.Timeout = timeout
+	}
+
+	if v := os.Getenv(CONVOY_BREAKER_COOLDOWN_ENV); len(v) != 0 {
+		cooldown, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", CONVOY_BREAKER_COOLDOWN_ENV, err)
+		}
+		c.BreakerCooldown = cooldown
+	}
+
+	if v := os.Getenv(CONVOY_RATE_LIMIT_ENV); len(v) != 0 {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", CONVOY_RATE_LIMIT_ENV, err)
+		}
+		c.RateLimit = rate
+	}
+
+	ints := map[string]*int{
+		CONVOY_MAX_IDLE_CONNS_ENV:    &c.MaxIdleConns,
+		CONVOY_RATE_BURST_ENV:        &c.RateBurst,
+		CONVOY_BREAKER_THRESHOLD_ENV: &c.BreakerThreshold,
+	}
+	for env, n := range ints {
+		if v := os.Getenv(env); len(v) != 0 {
+			var err error
+			if *n, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+
+	return c, nil
+}
@@ -1,6 +1,7 @@
 package ingester
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -38,3 +39,90 @@ func Test_LoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func Test_LoadConfig_EncryptedValues(t *testing.T) {
+	kw, err := NewLocalKeyWrapper([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	secret, err := EncryptConfigValue(kw, "paystack", "verifier_config.secret", []byte("Paystack Secret"))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		keyWrapper    KeyWrapper
+		provider      string
+		expectedError error
+	}{
+		"valid_value": {
+			keyWrapper: kw,
+			provider:   "paystack",
+		},
+		"value_bound_to_another_provider": {
+			keyWrapper:    kw,
+			provider:      "flutterwave",
+			expectedError: ErrInvalidEncryptedValue,
+		},
+		"missing_master_key": {
+			keyWrapper:    nil,
+			provider:      "paystack",
+			expectedError: ErrKeyWrapperNotConfigured,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			keyWrapper = tc.keyWrapper
+			defer func() { keyWrapper = nil }()
+
+			t.Setenv(CONFIG_ENV, `[
+				{
+					"name": "`+tc.provider+`",
+					"verifier_config": {
+						"type": "hmac",
+						"header": "X-Paystack-Signature",
+						"hash": "SHA512",
+						"secret": "`+secret+`"
+					}
+				}
+			]`)
+
+			// Act
+			err := LoadConfig(CONFIG_ENV)
+
+			// Assert
+			require.ErrorIs(t, err, tc.expectedError)
+			if tc.expectedError == nil {
+				require.Equal(t, "Paystack Secret", (*configStore)[0].VerifierConfig.HmacConfig.Secret)
+			}
+		})
+	}
+}
+
+func Test_LoadConfig_LargeNumbers(t *testing.T) {
+	tests := map[string]struct {
+		preset string
+	}{
+		"plain":  {preset: ""},
+		"preset": {preset: "github"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			t.Setenv(CONFIG_ENV, `[
+				{
+					"name": "discord",
+					"preset": "`+tc.preset+`",
+					"transform": [{"op": "set", "path": "$.guild_id", "value": 820982911946154508}]
+				}
+			]`)
+
+			// Act
+			err := LoadConfig(CONFIG_ENV)
+
+			// Assert
+			require.NoError(t, err)
+			require.Equal(t, json.Number("820982911946154508"), (*configStore)[0].Transform[0].Value)
+		})
+	}
+}
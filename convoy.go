@@ -0,0 +1,259 @@
+package ingester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	convoyModels "github.com/frain-dev/convoy-go/models"
+)
+
+const (
+	defaultConvoyTimeout      = 10 * time.Second
+	defaultConvoyMaxIdleConns = 10
+)
+
+var (
+	ErrInvalidAPIKeyEnv = errors.New("Convoy API key env must start with CONVOY_")
+
+	// ErrConvoyNotConfigured is permanent, redelivering the event won't
+	// give it a URL.
+	ErrConvoyNotConfigured = permanent(errors.New("Convoy URL is not configured"))
+
+	// ErrProjectNotConfigured is permanent, the event's target lacks it.
+	ErrProjectNotConfigured = permanent(errors.New("Convoy project is not configured"))
+)
+
+// convoyClient creates events on a Convoy instance. Unlike convoy-go it
+// keeps the response status, so failures can be told apart.
+type convoyClient struct {
+	config     ConvoyConfig
+	httpClient *http.Client
+}
+
+func newConvoyClient(c *ConvoyConfig) *convoyClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = c.MaxIdleConns
+	transport.MaxIdleConnsPerHost = c.MaxIdleConns
+
+	return &convoyClient{
+		config:     *c,
+		httpClient: &http.Client{Transport: transport},
+	}
+}
+
+// convoyTarget is the Convoy tenant of a provider, read from its
+// ConvoyTargetConfig.
+type convoyTarget struct {
+	URL       string
+	GroupID   string
+	ProjectID string
+	APIKeyEnv string
+
+	API          string
+	SourceMaskID string
+}
+
+// WithTarget returns a client pushing to t, sharing c's connections.
+func (c *convoyClient) WithTarget(t *convoyTarget) (*convoyClient, error) {
+	if t == nil {
+		return c, nil
+	}
+
+	cc := *c
+	if len(t.URL) != 0 {
+		cc.config.URL = t.URL
+	}
+
+	// The target's group or project replaces both global ones, so neither
+	// API falls back to the global group over the target's project.
+	if len(t.GroupID) != 0 || len(t.ProjectID) != 0 {
+		cc.config.GroupID, cc.config.ProjectID = t.GroupID, t.ProjectID
+	}
+
+	if len(t.APIKeyEnv) != 0 {
+		if !strings.HasPrefix(t.APIKeyEnv, "CONVOY_") {
+			return nil, permanent(ErrInvalidAPIKeyEnv)
+		}
+
+		key := os.Getenv(t.APIKeyEnv)
+		if len(key) == 0 {
+			return nil, fmt.Errorf("%s is not set", t.APIKeyEnv)
+		}
+
+		return cc.WithAPIKey(key), nil
+	}
+
+	return &cc, nil
+}
+
+// WithAPIKey returns a client authenticating with key, sharing c's
+// connections.
+func (c *convoyClient) WithAPIKey(key string) *convoyClient {
+	cc := *c
+	cc.config.APIKey = key
+	cc.config.Username, cc.config.Password = "", ""
+
+	return &cc
+}
+
+// WithTimeout returns a client whose requests time out after d, sharing
+// c's connections.
+func (c *convoyClient) WithTimeout(d time.Duration) *convoyClient {
+	cc := *c
+	cc.config.Timeout = d
+
+	return &cc
+}
+
+// projectEvent is an event for Convoy's project-scoped events API. Events
+// go to EndpointID, or to every endpoint of OwnerID when fanned out.
+type projectEvent struct {
+	EndpointID     string          `json:"endpoint_id,omitempty"`
+	OwnerID        string          `json:"owner_id,omitempty"`
+	EventType      string          `json:"event_type"`
+	Data           json.RawMessage `json:"data"`
+	IdempotencyKey string          `json:"idempotency_key,omitempty"`
+
+	// CustomHeaders are sent with each delivery of the event.
+	CustomHeaders map[string]string `json:"custom_headers,omitempty"`
+}
+
+// appEvent is an event for Convoy's legacy apps API.
+type appEvent struct {
+	convoyModels.EventRequest
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// CreateAppEvent sends event to its Convoy app.
+func (c *convoyClient) CreateAppEvent(ctx context.Context, event *appEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return permanent(err)
+	}
+
+	// Convoy renamed groups to projects; the legacy API takes either.
+	groupID := c.config.GroupID
+	if len(groupID) == 0 {
+		groupID = c.config.ProjectID
+	}
+
+	query := url.Values{}
+	if len(groupID) != 0 {
+		query.Set("groupID", groupID)
+	}
+
+	return c.post(ctx, "events", query, nil, b, true)
+}
+
+// CreateProjectEvent sends event with the project-scoped events API, or
+// its fan-out variant.
+func (c *convoyClient) CreateProjectEvent(ctx context.Context, event *projectEvent, fanout bool) error {
+	projectID := c.config.ProjectID
+	if len(projectID) == 0 {
+		projectID = c.config.GroupID
+	}
+
+	if len(projectID) == 0 {
+		return ErrProjectNotConfigured
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return permanent(err)
+	}
+
+	path := "projects/" + url.PathEscape(projectID) + "/events"
+	if fanout {
+		path += "/fanout"
+	}
+
+	return c.post(ctx, path, nil, nil, b, true)
+}
+
+// convoyIdempotencyKeyHeader carries the idempotency key of ingested
+// payloads. Add it to the source's idempotency keys for Convoy to use it.
+const convoyIdempotencyKeyHeader = "X-Convoy-Idempotency-Key"
+
+// Ingest sends payload to the incoming source with maskID, as a provider
+// would. Ingest URLs are served from the root of Convoy's host, and aren't
+// authenticated with the API key.
+func (c *convoyClient) Ingest(ctx context.Context, maskID string, payload []byte, idempotencyKey string) error {
+	header := http.Header{}
+	if len(idempotencyKey) != 0 {
+		header.Set(convoyIdempotencyKeyHeader, idempotencyKey)
+	}
+
+	return c.post(ctx, "/ingest/"+url.PathEscape(maskID), nil, header, payload, false)
+}
+
+// post sends body to path, resolved against the configured URL, with
+// header added. The request is cancelled when ctx is done or the client's
+// timeout passes.
+func (c *convoyClient) post(ctx context.Context, path string, query url.Values, header http.Header, body []byte, auth bool) error {
+	if len(c.config.URL) == 0 {
+		return ErrConvoyNotConfigured
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(c.config.URL, "/") + "/")
+	if err != nil {
+		return permanent(err)
+	}
+
+	ref, err := url.Parse(path)
+	if err != nil {
+		return permanent(err)
+	}
+
+	endpoint := base.ResolveReference(ref)
+	endpoint.RawQuery = query.Encode()
+
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	if auth && len(c.config.APIKey) != 0 {
+		req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	} else if auth && len(c.config.Username) != 0 && len(c.config.Password) != 0 {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	var apiResp convoyModels.APIResponse
+	message := string(b)
+	if err := json.Unmarshal(b, &apiResp); err == nil && len(apiResp.Message) != 0 {
+		message = apiResp.Message
+	}
+
+	return &statusError{StatusCode: resp.StatusCode, Message: message}
+}
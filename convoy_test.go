@@ -0,0 +1,164 @@
+package ingester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	convoyModels "github.com/frain-dev/convoy-go/models"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ConvoyClient_CreateAppEvent(t *testing.T) {
+	t.Setenv("CONVOY_TENANT_API_KEY", "tenant-key")
+
+	tests := map[string]struct {
+		target          *convoyTarget
+		expectedGroupID string
+		expectedAuth    string
+	}{
+		"default": {
+			target:          nil,
+			expectedGroupID: "group-id",
+			expectedAuth:    "Bearer api-key",
+		},
+		"group": {
+			target:          &convoyTarget{GroupID: "other-group"},
+			expectedGroupID: "other-group",
+			expectedAuth:    "Bearer api-key",
+		},
+		"project": {
+			target:          &convoyTarget{ProjectID: "project-id"},
+			expectedGroupID: "project-id",
+			expectedAuth:    "Bearer api-key",
+		},
+		"api key env": {
+			target:          &convoyTarget{APIKeyEnv: "CONVOY_TENANT_API_KEY"},
+			expectedGroupID: "group-id",
+			expectedAuth:    "Bearer tenant-key",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			var groupID, auth string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/events", r.URL.Path)
+				groupID = r.URL.Query().Get("groupID")
+				auth = r.Header.Get("Authorization")
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer srv.Close()
+
+			c, err := newConvoyClient(&ConvoyConfig{URL: "https://convoy.example.com", GroupID: "group-id", APIKey: "api-key"}).
+				WithTarget(&convoyTarget{URL: srv.URL + "/"})
+			require.NoError(t, err)
+
+			c, err = c.WithTarget(tc.target)
+			require.NoError(t, err)
+
+			// Act
+			err = c.CreateAppEvent(context.Background(), &appEvent{EventRequest: convoyModels.EventRequest{AppID: "app-id"}})
+
+			// Assert
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedGroupID, groupID)
+			require.Equal(t, tc.expectedAuth, auth)
+		})
+	}
+}
+
+func Test_ConvoyClient_WithTarget_APIKeyEnv(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "/secrets/key.json")
+	c := newConvoyClient(&ConvoyConfig{})
+
+	_, err := c.WithTarget(&convoyTarget{APIKeyEnv: "GOOGLE_APPLICATION_CREDENTIALS"})
+	require.ErrorIs(t, err, ErrInvalidAPIKeyEnv)
+	require.True(t, isPermanent(err))
+
+	_, err = c.WithTarget(&convoyTarget{APIKeyEnv: "CONVOY_UNSET_API_KEY"})
+	require.Error(t, err)
+	require.False(t, isPermanent(err))
+}
+
+func Test_ConvoyClient_NotConfigured(t *testing.T) {
+	c := newConvoyClient(&ConvoyConfig{})
+
+	err := c.CreateAppEvent(context.Background(), &appEvent{})
+	require.ErrorIs(t, err, ErrConvoyNotConfigured)
+	require.True(t, isPermanent(err))
+}
+
+func Test_ConvoyClient_Cancellation(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	tests := map[string]struct {
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+	}{
+		"attempt timeout": {
+			timeout: 20 * time.Millisecond,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+		},
+		"invocation deadline": {
+			timeout: time.Minute,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			c := newConvoyClient(&ConvoyConfig{URL: srv.URL}).WithTimeout(tc.timeout)
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			// Act
+			err := c.CreateAppEvent(ctx, &appEvent{EventRequest: convoyModels.EventRequest{AppID: "app-id"}})
+
+			// Assert
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			require.False(t, isPermanent(err))
+		})
+	}
+}
+
+func Test_LoadConvoyConfig(t *testing.T) {
+	// Arrange
+	t.Setenv(CONVOY_URL_ENV, "https://convoy.example.com/api/v1")
+	t.Setenv(CONVOY_GROUP_ID_ENV, "group-id")
+	t.Setenv(CONVOY_API_KEY_ENV, "api-key")
+	t.Setenv(CONVOY_TIMEOUT_ENV, "5s")
+
+	// Act
+	c, err := LoadConvoyConfig()
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, &ConvoyConfig{
+		URL:          "https://convoy.example.com/api/v1",
+		GroupID:      "group-id",
+		APIKey:       "api-key",
+		Timeout:      5 * time.Second,
+		MaxIdleConns: defaultConvoyMaxIdleConns,
+
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
+	}, c)
+
+	t.Setenv(CONVOY_TIMEOUT_ENV, "soon")
+	_, err = LoadConvoyConfig()
+	require.Error(t, err)
+}
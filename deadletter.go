@@ -0,0 +1,415 @@
+package ingester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrDeadLetterNotFound = errors.New("Dead letter not found")
+
+// DeadLetter is an event PushToConvoy couldn't deliver.
+type DeadLetter struct {
+	ID string `json:"id"`
+
+	// request is the parsed event, or nil when the message couldn't be
+	// parsed. It is encoded as "request", so it can be edited.
+	request *convoyRequest
+
+	// Data and Attributes are the original Pub/Sub message.
+	Data       []byte            `json:"data"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	Error     string    `json:"error"`
+	Attempts  []Attempt `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// deadLetterJSON is a DeadLetter without its methods, so it can be encoded
+// along with the parsed request.
+type deadLetterJSON DeadLetter
+
+func (d *DeadLetter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*deadLetterJSON
+		Request *convoyRequest `json:"request,omitempty"`
+	}{(*deadLetterJSON)(d), d.request})
+}
+
+func (d *DeadLetter) UnmarshalJSON(data []byte) error {
+	v := struct {
+		*deadLetterJSON
+		Request *convoyRequest `json:"request,omitempty"`
+	}{deadLetterJSON: (*deadLetterJSON)(d)}
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	d.request = v.Request
+	return nil
+}
+
+// Attempt is one failed delivery.
+type Attempt struct {
+	At    time.Time `json:"at"`
+	Error string    `json:"error"`
+}
+
+func newDeadLetter(m pubSubMessage, req *convoyRequest, cause error, attempts []Attempt) *DeadLetter {
+	return &DeadLetter{
+		ID:         uuid.New().String(),
+		request:    req,
+		Data:       m.Data,
+		Attributes: m.Attributes,
+		Error:      cause.Error(),
+		Attempts:   attempts,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+// Message returns the Pub/Sub message that replays d. Edits to the parsed
+// request take precedence over the original message.
+func (d *DeadLetter) Message() *pubsub.Message {
+	if d.request == nil {
+		return &pubsub.Message{Data: d.Data, Attributes: d.Attributes}
+	}
+
+	return &pubsub.Message{Data: d.request.Data.Data, Attributes: d.request.Attributes(d.Attributes[attrFormat])}
+}
+
+// Replay re-publishes d to topic and returns the message ID.
+func (d *DeadLetter) Replay(ctx context.Context, topic *pubsub.Topic) (string, error) {
+	return topic.Publish(ctx, d.Message()).Get(ctx)
+}
+
+// DeadLetterSink keeps events that can't be delivered.
+type DeadLetterSink interface {
+	DeadLetter(d *DeadLetter) error
+}
+
+// DeadLetterStore is a sink whose events can be inspected, edited and
+// removed once replayed.
+type DeadLetterStore interface {
+	DeadLetterSink
+
+	// List returns the stored events, oldest first.
+	List() ([]*DeadLetter, error)
+
+	// Get returns the event stored under id.
+	Get(id string) (*DeadLetter, error)
+
+	// Put stores d, replacing any event with the same ID.
+	Put(d *DeadLetter) error
+
+	// Delete removes the event stored under id.
+	Delete(id string) error
+}
+
+// logDeadLetterSink only logs dead-lettered events.
+type logDeadLetterSink struct{}
+
+func (logDeadLetterSink) DeadLetter(d *DeadLetter) error {
+	log.WithField("attributes", d.Attributes).WithField("attempts", len(d.Attempts)).
+		Errorf("Dead-lettered event %s: %s", d.ID, d.Error)
+	return nil
+}
+
+// topicDeadLetterSink publishes dead-lettered events to a Pub/Sub topic.
+type topicDeadLetterSink struct {
+	topic *pubsub.Topic
+}
+
+func (s *topicDeadLetterSink) DeadLetter(d *DeadLetter) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = s.topic.Publish(ctx, &pubsub.Message{Data: b, Attributes: d.Attributes}).Get(ctx)
+	return err
+}
+
+// subscriptionDeadLetterStore reads the events a topicDeadLetterSink
+// published from a subscription to its topic. Events are held while they
+// are read and nacked afterwards, so they stay in the subscription until
+// deleted.
+type subscriptionDeadLetterStore struct {
+	sub  *pubsub.Subscription
+	sink *topicDeadLetterSink
+
+	// wait is how long reads wait for another event before returning.
+	wait time.Duration
+}
+
+// defaultDeadLetterWait gives Pub/Sub time to deliver every held event.
+const defaultDeadLetterWait = 5 * time.Second
+
+// NewSubscriptionDeadLetterStore returns a store that reads events from
+// sub, a subscription to topic, the dead-letter topic. Only the events the
+// subscription can have outstanding at once, 1000 by default, are seen.
+func NewSubscriptionDeadLetterStore(sub *pubsub.Subscription, topic *pubsub.Topic) DeadLetterStore {
+	// Pulled synchronously, so no events are left leased to a stream when
+	// a read ends.
+	sub.ReceiveSettings.Synchronous = true
+
+	return &subscriptionDeadLetterStore{sub: sub, sink: &topicDeadLetterSink{topic: topic}, wait: defaultDeadLetterWait}
+}
+
+func (s *subscriptionDeadLetterStore) DeadLetter(d *DeadLetter) error {
+	return s.sink.DeadLetter(d)
+}
+
+func (s *subscriptionDeadLetterStore) List() ([]*DeadLetter, error) {
+	letters, err := s.receive(func(*pubsub.Message, *DeadLetter) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].CreatedAt.Before(letters[j].CreatedAt)
+	})
+
+	return letters, nil
+}
+
+func (s *subscriptionDeadLetterStore) Get(id string) (*DeadLetter, error) {
+	letters, err := s.receive(func(*pubsub.Message, *DeadLetter) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range letters {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+
+	return nil, ErrDeadLetterNotFound
+}
+
+// Put publishes d before acknowledging the event it replaces, so d is
+// never lost.
+func (s *subscriptionDeadLetterStore) Put(d *DeadLetter) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	msgID, err := s.sink.topic.Publish(ctx, &pubsub.Message{Data: b, Attributes: d.Attributes}).Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.receive(func(m *pubsub.Message, got *DeadLetter) bool {
+		return got.ID == d.ID && m.ID != msgID
+	})
+	return err
+}
+
+func (s *subscriptionDeadLetterStore) Delete(id string) error {
+	found := false
+	_, err := s.receive(func(m *pubsub.Message, d *DeadLetter) bool {
+		if d.ID != id {
+			return false
+		}
+
+		found = true
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrDeadLetterNotFound
+	}
+
+	return nil
+}
+
+// receive holds every event the subscription delivers until none arrives
+// for s.wait, then acknowledges those ack reports true for and nacks the
+// rest. Messages that aren't dead letters are nacked as they arrive.
+func (s *subscriptionDeadLetterStore) receive(ack func(m *pubsub.Message, d *DeadLetter) bool) ([]*DeadLetter, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	idle := time.NewTimer(s.wait)
+	defer idle.Stop()
+
+	received := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-idle.C:
+				cancel()
+				return
+			case <-received:
+				if !idle.Stop() {
+					<-idle.C
+				}
+				idle.Reset(s.wait)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var letters []*DeadLetter
+
+	err := s.sub.Receive(ctx, func(_ context.Context, m *pubsub.Message) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+
+		d := &DeadLetter{}
+		if err := json.Unmarshal(m.Data, d); err != nil {
+			m.Nack()
+			return
+		}
+
+		mu.Lock()
+		letters = append(letters, d)
+		mu.Unlock()
+
+		// Held so Pub/Sub doesn't deliver it again during the read.
+		<-ctx.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		if ack(m, d) {
+			m.Ack()
+		} else {
+			m.Nack()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return letters, nil
+}
+
+// directoryDeadLetterStore keeps each event as a JSON file in dir.
+type directoryDeadLetterStore struct {
+	dir string
+}
+
+// NewDirectoryDeadLetterStore returns a store that keeps events in dir,
+// creating it if needed. It is meant for the worker and ingesterctl:
+// Cloud Functions only have an in-memory /tmp that is lost with the
+// instance, so PushToConvoy uses the topic store.
+func NewDirectoryDeadLetterStore(dir string) (DeadLetterStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &directoryDeadLetterStore{dir: dir}, nil
+}
+
+func (s *directoryDeadLetterStore) DeadLetter(d *DeadLetter) error {
+	return s.Put(d)
+}
+
+func (s *directoryDeadLetterStore) List() ([]*DeadLetter, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*DeadLetter, 0, len(files))
+	for _, f := range files {
+		d, err := s.Get(strings.TrimSuffix(filepath.Base(f), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, d)
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].CreatedAt.Before(letters[j].CreatedAt)
+	})
+
+	return letters, nil
+}
+
+func (s *directoryDeadLetterStore) Get(id string) (*DeadLetter, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DeadLetter{}
+	if err := json.Unmarshal(b, d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Put writes d to a temporary file first, so readers never see a partial
+// event.
+func (s *directoryDeadLetterStore) Put(d *DeadLetter) error {
+	path, err := s.path(d.ID)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (s *directoryDeadLetterStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrDeadLetterNotFound
+	}
+
+	return err
+}
+
+// path rejects IDs that would escape the store's directory.
+func (s *directoryDeadLetterStore) path(id string) (string, error) {
+	if len(id) == 0 || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return "", ErrDeadLetterNotFound
+	}
+
+	return filepath.Join(s.dir, id+".json"), nil
+}
@@ -0,0 +1,153 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	convoyModels "github.com/frain-dev/convoy-go/models"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DirectoryDeadLetterStore(t *testing.T) {
+	// Arrange
+	store, err := NewDirectoryDeadLetterStore(t.TempDir())
+	require.NoError(t, err)
+
+	first := newDeadLetter(pubSubMessage{Data: []byte(`not json`)}, nil, errors.New("bad payload"), nil)
+	second := newDeadLetter(pubSubMessage{Data: []byte(`{}`)}, &convoyRequest{Provider: "paystack", Data: convoyModels.EventRequest{Data: []byte(`{}`)}}, errors.New("rejected"), []Attempt{{Error: "rejected"}})
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+
+	// Act
+	require.NoError(t, store.DeadLetter(second))
+	require.NoError(t, store.DeadLetter(first))
+
+	// Assert
+	letters, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	require.Equal(t, first.ID, letters[0].ID)
+	require.Equal(t, second.ID, letters[1].ID)
+
+	got, err := store.Get(second.ID)
+	require.NoError(t, err)
+	require.Equal(t, "rejected", got.Error)
+	require.Equal(t, second.request, got.request)
+	require.Equal(t, []byte(`{}`), got.Data)
+	require.Len(t, got.Attempts, 1)
+
+	got.Error = "edited"
+	require.NoError(t, store.Put(got))
+	got, err = store.Get(second.ID)
+	require.NoError(t, err)
+	require.Equal(t, "edited", got.Error)
+
+	require.NoError(t, store.Delete(first.ID))
+	_, err = store.Get(first.ID)
+	require.Equal(t, ErrDeadLetterNotFound, err)
+	require.Equal(t, ErrDeadLetterNotFound, store.Delete(first.ID))
+
+	_, err = store.Get("../" + second.ID)
+	require.Equal(t, ErrDeadLetterNotFound, err)
+}
+
+func Test_DeadLetter_Message(t *testing.T) {
+	m := pubSubMessage{
+		Data: []byte(`{"id":302961}`),
+		Attributes: map[string]string{
+			"provider":   "paystack",
+			"app_id":     "app-id",
+			"event_type": "charge.success",
+			"request_id": "req-1",
+		},
+	}
+
+	tests := map[string]struct {
+		request            *convoyRequest
+		expectedData       string
+		expectedAttributes map[string]string
+	}{
+		"unparsed message": {
+			request:            nil,
+			expectedData:       `{"id":302961}`,
+			expectedAttributes: m.Attributes,
+		},
+		"edited request": {
+			request: &convoyRequest{
+				Data: convoyModels.EventRequest{
+					AppID: "other-app",
+					Event: "charge.success",
+					Data:  []byte(`{"id":302962}`),
+				},
+				Provider:  "paystack",
+				RequestID: "req-1",
+			},
+			expectedData: `{"id":302962}`,
+			expectedAttributes: map[string]string{
+				"provider":   "paystack",
+				"app_id":     "other-app",
+				"event_type": "charge.success",
+				"request_id": "req-1",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			d := newDeadLetter(m, tc.request, errors.New("rejected"), nil)
+
+			// Act
+			msg := d.Message()
+
+			// Assert
+			require.Equal(t, tc.expectedData, string(msg.Data))
+			require.Equal(t, tc.expectedAttributes, msg.Attributes)
+		})
+	}
+}
+
+func Test_SubscriptionDeadLetterStore(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	_, topic, sub := newTestSubscription(t, ctx)
+	store := NewSubscriptionDeadLetterStore(sub, topic).(*subscriptionDeadLetterStore)
+	store.wait = 500 * time.Millisecond
+
+	req := &convoyRequest{Data: convoyModels.EventRequest{AppID: "app-id", Data: []byte(`{"id":302961}`)}}
+	first := newDeadLetter(pubSubMessage{Data: []byte(`{"id":302961}`)}, req, errors.New("rejected"), nil)
+	second := newDeadLetter(pubSubMessage{Data: []byte(`not json`)}, nil, errors.New("bad payload"), nil)
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+
+	// Act
+	require.NoError(t, store.DeadLetter(second))
+	require.NoError(t, store.DeadLetter(first))
+
+	// Assert: reads leave events in the subscription.
+	for i := 0; i < 2; i++ {
+		letters, err := store.List()
+		require.NoError(t, err)
+		require.Len(t, letters, 2)
+		require.Equal(t, first.ID, letters[0].ID)
+		require.Equal(t, second.ID, letters[1].ID)
+	}
+
+	got, err := store.Get(first.ID)
+	require.NoError(t, err)
+	require.Equal(t, req, got.request)
+
+	got.Error = "edited"
+	require.NoError(t, store.Put(got))
+	letters, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	got, err = store.Get(first.ID)
+	require.NoError(t, err)
+	require.Equal(t, "edited", got.Error)
+
+	require.NoError(t, store.Delete(first.ID))
+	_, err = store.Get(first.ID)
+	require.Equal(t, ErrDeadLetterNotFound, err)
+	require.Equal(t, ErrDeadLetterNotFound, store.Delete(first.ID))
+}
@@ -0,0 +1,151 @@
+package ingester
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdempotencyTTL = 24 * time.Hour
+
+	// inFlightIdempotencyTTL is how long a key is reserved while its event
+	// is being delivered. It outlasts a function's longest invocation, so
+	// a crashed delivery only holds the key until it expires.
+	inFlightIdempotencyTTL = 10 * time.Minute
+)
+
+// DedupeStore remembers idempotency keys so provider retries are published
+// once. A key is reserved while its event is in flight, and confirmed once
+// the event is delivered.
+type DedupeStore interface {
+	// Reserve records key for ttl. It reports false when the key is
+	// already recorded.
+	Reserve(key string, ttl time.Duration) (bool, error)
+
+	// Confirm keeps key for ttl after its event was delivered.
+	Confirm(key string, ttl time.Duration) error
+
+	// Confirmed reports whether key was confirmed, rather than reserved by
+	// a delivery still in flight.
+	Confirmed(key string) (bool, error)
+
+	// Release forgets key, so a failed delivery can be retried.
+	Release(key string) error
+}
+
+// idempotencyKeyExtractor reads a webhook's idempotency key.
+type idempotencyKeyExtractor struct {
+	config *IdempotencyConfig
+	ttl    time.Duration
+}
+
+func newIdempotencyKeyExtractor(c *IdempotencyConfig) (*idempotencyKeyExtractor, error) {
+	e := &idempotencyKeyExtractor{config: c, ttl: defaultIdempotencyTTL}
+	if c == nil || len(c.TTL) == 0 {
+		return e, nil
+	}
+
+	ttl, err := time.ParseDuration(c.TTL)
+	if err != nil {
+		return nil, err
+	}
+	e.ttl = ttl
+
+	return e, nil
+}
+
+// Extract returns the key from the configured header, or else the JSON path.
+// It returns an empty string when deduplication is off or no key is found.
+func (e *idempotencyKeyExtractor) Extract(r *http.Request, payload []byte) string {
+	if e.config == nil {
+		return ""
+	}
+
+	var key string
+	if len(e.config.Header) != 0 {
+		key = strings.TrimSpace(r.Header.Get(e.config.Header))
+	}
+
+	if len(key) == 0 && len(e.config.Path) != 0 {
+		key, _ = lookupPathString(payload, e.config.Path)
+	}
+
+	return key
+}
+
+// memoryDedupeStore keeps keys in process memory. Each function instance
+// has its own copy, so use Redis when running more than one instance.
+type memoryDedupeStore struct {
+	mu        sync.Mutex
+	keys      map[string]dedupeKey
+	lastEvict time.Time
+	now       func() time.Time
+}
+
+type dedupeKey struct {
+	expires   time.Time
+	confirmed bool
+}
+
+func newMemoryDedupeStore() *memoryDedupeStore {
+	return &memoryDedupeStore{
+		keys: make(map[string]dedupeKey),
+		now:  time.Now,
+	}
+}
+
+func (m *memoryDedupeStore) Reserve(key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if k, ok := m.keys[key]; ok && now.Before(k.expires) {
+		return false, nil
+	}
+
+	m.keys[key] = dedupeKey{expires: now.Add(ttl)}
+	m.evict(now)
+
+	return true, nil
+}
+
+func (m *memoryDedupeStore) Confirm(key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys[key] = dedupeKey{expires: m.now().Add(ttl), confirmed: true}
+	return nil
+}
+
+func (m *memoryDedupeStore) Confirmed(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.keys[key]
+	return ok && k.confirmed && m.now().Before(k.expires), nil
+}
+
+func (m *memoryDedupeStore) Release(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, key)
+	return nil
+}
+
+// evict drops expired keys at most once a minute. It must be called with
+// mu held.
+func (m *memoryDedupeStore) evict(now time.Time) {
+	if now.Sub(m.lastEvict) < time.Minute {
+		return
+	}
+	m.lastEvict = now
+
+	for k, v := range m.keys {
+		if !now.Before(v.expires) {
+			delete(m.keys, k)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package ingester
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// delivery is a queued event on its way to its target.
+type delivery struct {
+	m         pubSubMessage
+	req       *convoyRequest
+	forwarder Forwarder
+
+	// target names the destination, so deliveries to it can be batched.
+	target string
+
+	// key is the reserved idempotency key, confirmed once delivered or
+	// released if delivery fails.
+	key string
+}
+
+// prepareDelivery parses m, reserves its idempotency key and picks its
+// forwarder. It returns a nil delivery when m needs no forwarding, with
+// the error to settle m with: nil for duplicate and dead-lettered
+// messages.
+func prepareDelivery(m pubSubMessage) (*delivery, error) {
+	req := &convoyRequest{}
+	if err := req.FromMessage(m); err != nil {
+		return nil, deadLetter(newDeadLetter(m, nil, permanent(fmt.Errorf("Failed to parse payload: %w", err)), nil))
+	}
+
+	// Skip messages Pub/Sub redelivers after a successful push. Copies
+	// redelivered while the first one is in flight are retried later, in
+	// case it fails.
+	key := ""
+	if len(req.IdempotencyKey) != 0 {
+		key = "push:" + req.Data.AppID + ":" + req.IdempotencyKey
+		ok, err := dedupeStore.Reserve(key, inFlightIdempotencyTTL)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Server Error: Failed to check idempotency key - %+v", err))
+		}
+
+		if !ok {
+			confirmed, err := dedupeStore.Confirmed(key)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Server Error: Failed to check idempotency key - %+v", err))
+			}
+
+			if !confirmed {
+				return nil, errors.New(fmt.Sprintf("Server Error: Event is in flight, key: %s", req.IdempotencyKey))
+			}
+
+			log.Printf("Skipping duplicate event, key: %s", req.IdempotencyKey)
+			return nil, nil
+		}
+	}
+
+	// Actual push to Convoy, or the provider's HTTP target.
+	forwarder, target, err := forwarderFor(req)
+	if err != nil {
+		releaseIdempotencyKey(key)
+		if isPermanent(err) {
+			return nil, deadLetter(newDeadLetter(m, req, err, nil))
+		}
+		return nil, errors.New(fmt.Sprintf("Server Error: Failed to set up forwarder - %+v", err))
+	}
+
+	return &delivery{m: m, req: req, forwarder: forwarder, target: target, key: key}, nil
+}
+
+// finish settles d after forwarding it failed with err, or succeeded when
+// err is nil. It returns nil when d's message should be acknowledged.
+func (d *delivery) finish(attempts []Attempt, err error) error {
+	if err == nil {
+		confirmIdempotencyKey(d.key, defaultIdempotencyTTL)
+		return nil
+	}
+
+	releaseIdempotencyKey(d.key)
+	if isPermanent(err) {
+		return deadLetter(newDeadLetter(d.m, d.req, err, attempts))
+	}
+
+	// Pub/Sub redelivers the message after the subscription's backoff.
+	// The error is wrapped so the worker can tell how long to hold it.
+	if isPaused(err) {
+		eventsDeferred.Add(err.Error(), 1)
+	}
+	return fmt.Errorf("Server Error: Failed to forward event - %w", err)
+}
@@ -0,0 +1,240 @@
+package ingester
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// encryptedPrefix marks a configuration value sealed with EncryptConfigValue.
+const encryptedPrefix = "enc:"
+
+var ErrKeyWrapperNotConfigured = errors.New("Encrypted config value found but no master key is configured")
+var ErrInvalidEncryptedValue = errors.New("Invalid encrypted config value")
+var ErrInvalidMasterKey = errors.New("Master key must be 32 bytes")
+
+// KeyWrapper protects the data keys used to seal configuration values.
+// LocalKeyWrapper uses a key held in the environment, a KMS backed
+// implementation only needs to satisfy this interface.
+type KeyWrapper interface {
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyWrapper wraps data keys with a local AES-256 master key.
+type LocalKeyWrapper struct {
+	masterKey []byte
+}
+
+func NewLocalKeyWrapper(masterKey []byte) (*LocalKeyWrapper, error) {
+	if len(masterKey) != 32 {
+		return nil, ErrInvalidMasterKey
+	}
+
+	return &LocalKeyWrapper{masterKey: masterKey}, nil
+}
+
+func (lw *LocalKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	return seal(lw.masterKey, dataKey, nil)
+}
+
+func (lw *LocalKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return open(lw.masterKey, wrapped, nil)
+}
+
+// NewKeyWrapperFromEnv builds a LocalKeyWrapper from a base64 encoded
+// master key. It returns nil when the variable is not set.
+func NewKeyWrapperFromEnv(env string) (KeyWrapper, error) {
+	k := os.Getenv(env)
+	if len(strings.TrimSpace(k)) == 0 {
+		return nil, nil
+	}
+
+	masterKey, err := base64.StdEncoding.DecodeString(k)
+	if err != nil {
+		return nil, ErrInvalidMasterKey
+	}
+
+	lw, err := NewLocalKeyWrapper(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return lw, nil
+}
+
+// SetKeyWrapper sets the key LoadConfig opens encrypted values with, for
+// tools that load the configuration outside the functions.
+func SetKeyWrapper(kw KeyWrapper) {
+	keyWrapper = kw
+}
+
+// EncryptConfigValue seals plaintext with a fresh data key. The ciphertext
+// is bound to the provider and field, so it cannot be moved to another one.
+func EncryptConfigValue(kw KeyWrapper, provider, field string, plaintext []byte) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	wrapped, err := kw.WrapKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := seal(dataKey, plaintext, associatedData(provider, field))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptConfigValue opens a value produced by EncryptConfigValue.
+func DecryptConfigValue(kw KeyWrapper, provider, field, value string) (string, error) {
+	if kw == nil {
+		return "", ErrKeyWrapperNotConfigured
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 2 {
+		return "", ErrInvalidEncryptedValue
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidEncryptedValue
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidEncryptedValue
+	}
+
+	dataKey, err := kw.UnwrapKey(wrapped)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(dataKey, sealed, associatedData(provider, field))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// decryptConfig replaces every encrypted string in the raw configuration
+// with its plaintext. Numbers are kept as json.Number, so large integers
+// aren't rounded on the way through.
+func decryptConfig(kw KeyWrapper, data []byte) ([]byte, error) {
+	var providers []map[string]interface{}
+	if err := unmarshalJSON(data, &providers); err != nil {
+		return nil, err
+	}
+
+	for _, p := range providers {
+		name, _ := p["name"].(string)
+		for k, v := range p {
+			d, err := decryptValue(kw, name, k, v)
+			if err != nil {
+				return nil, err
+			}
+			p[k] = d
+		}
+	}
+
+	return json.Marshal(providers)
+}
+
+func decryptValue(kw KeyWrapper, provider, field string, v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		if !strings.HasPrefix(val, encryptedPrefix) {
+			return val, nil
+		}
+
+		d, err := DecryptConfigValue(kw, provider, field, val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to decrypt %s - %w", provider, field, err)
+		}
+		return d, nil
+	case map[string]interface{}:
+		for k, e := range val {
+			d, err := decryptValue(kw, provider, field+"."+k, e)
+			if err != nil {
+				return nil, err
+			}
+			val[k] = d
+		}
+		return val, nil
+	case []interface{}:
+		for i, e := range val {
+			d, err := decryptValue(kw, provider, field+"."+strconv.Itoa(i), e)
+			if err != nil {
+				return nil, err
+			}
+			val[i] = d
+		}
+		return val, nil
+	default:
+		return v, nil
+	}
+}
+
+func associatedData(provider, field string) []byte {
+	return []byte(provider + "/" + field)
+}
+
+// seal encrypts plaintext with AES-GCM and prepends the nonce.
+func seal(key, plaintext, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func open(key, sealed, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrInvalidEncryptedValue
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, ErrInvalidEncryptedValue
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
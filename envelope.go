@@ -0,0 +1,120 @@
+package ingester
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const redactedValue = "[REDACTED]"
+
+// defaultRedactedHeaders carry credentials and are never forwarded in the
+// clear. The provider's API key header is added to these.
+var defaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"X-Api-Key",
+}
+
+// envelope wraps payloads with the metadata of the request they came in.
+type envelope struct {
+	provider string
+	config   *EnvelopeConfig
+	redact   map[string]bool
+	now      func() time.Time
+}
+
+func newEnvelope(provider string, c *EnvelopeConfig, secretHeader string) *envelope {
+	e := &envelope{
+		provider: provider,
+		config:   c,
+		redact:   make(map[string]bool),
+		now:      time.Now,
+	}
+
+	headers := append(append([]string{}, defaultRedactedHeaders...), c.Redact...)
+	if len(secretHeader) != 0 {
+		headers = append(headers, secretHeader)
+	}
+
+	for _, h := range headers {
+		e.redact[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return e
+}
+
+// Wrap nests payload under "payload" next to the provider name, receipt
+// time and the configured request metadata.
+func (e *envelope) Wrap(r *http.Request, payload []byte) ([]byte, error) {
+	out := map[string]interface{}{
+		"provider":    e.provider,
+		"received_at": e.now().UTC().Format(time.RFC3339Nano),
+		"payload":     json.RawMessage(payload),
+	}
+
+	if len(e.config.Headers) != 0 {
+		out["headers"] = e.headers(r.Header)
+	}
+
+	if e.config.Query {
+		query := make(map[string]string)
+		for k, v := range r.URL.Query() {
+			query[k] = strings.Join(v, ",")
+		}
+		out["query"] = query
+	}
+
+	if e.config.SourceIP {
+		out["source_ip"] = sourceIP(r)
+	}
+
+	return json.Marshal(out)
+}
+
+func (e *envelope) headers(h http.Header) map[string]string {
+	all := len(e.config.Headers) == 1 && e.config.Headers[0] == "*"
+
+	names := e.config.Headers
+	if all {
+		names = make([]string, 0, len(h))
+		for k := range h {
+			names = append(names, k)
+		}
+	}
+
+	headers := make(map[string]string, len(names))
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		v, ok := h[name]
+		if !ok {
+			continue
+		}
+
+		if e.redact[name] {
+			headers[name] = redactedValue
+			continue
+		}
+		headers[name] = strings.Join(v, ", ")
+	}
+
+	return headers
+}
+
+// sourceIP returns the client's public address from the proxy headers, or
+// else the address of the connection.
+func sourceIP(r *http.Request) string {
+	if ip := getIPAddress(r); len(ip) != 0 {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
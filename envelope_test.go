@@ -0,0 +1,65 @@
+package ingester
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Envelope_Wrap(t *testing.T) {
+	receivedAt := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		config          *EnvelopeConfig
+		secretHeader    string
+		expectedPayload string
+	}{
+		"selected_headers": {
+			config: &EnvelopeConfig{Headers: []string{"x-github-delivery", "X-Missing"}},
+			expectedPayload: `{"headers":{"X-Github-Delivery":"72d3162e"},"payload":{"action":"opened"},` +
+				`"provider":"github","received_at":"2022-06-01T12:00:00Z"}`,
+		},
+		"all_headers_redacted_by_default": {
+			config:       &EnvelopeConfig{Headers: []string{"*"}, Redact: []string{"X-Internal-Token"}},
+			secretHeader: "mono-webhook-secret",
+			expectedPayload: `{"headers":{"Authorization":"[REDACTED]","Mono-Webhook-Secret":"[REDACTED]",` +
+				`"X-Github-Delivery":"72d3162e","X-Internal-Token":"[REDACTED]"},"payload":{"action":"opened"},` +
+				`"provider":"github","received_at":"2022-06-01T12:00:00Z"}`,
+		},
+		"query_and_source_ip": {
+			config: &EnvelopeConfig{Query: true, SourceIP: true},
+			expectedPayload: `{"payload":{"action":"opened"},"provider":"github","query":{"tenant":"acme"},` +
+				`"received_at":"2022-06-01T12:00:00Z","source_ip":"52.52.52.52"}`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			e := newEnvelope("github", tc.config, tc.secretHeader)
+			e.now = func() time.Time { return receivedAt }
+
+			req, err := http.NewRequest("POST", "/v1/webhooks/github?tenant=acme", strings.NewReader(``))
+			require.NoError(t, err)
+
+			req.Header.Add("X-GitHub-Delivery", "72d3162e")
+			req.Header.Add("Authorization", "Bearer secret")
+			req.Header.Add("Mono-Webhook-Secret", "sec_secretphrase")
+			req.Header.Add("X-Internal-Token", "internal")
+			req.RemoteAddr = "10.0.0.1:41234"
+			if tc.config.SourceIP {
+				req.Header = http.Header{"X-Forwarded-For": []string{"52.52.52.52, 10.0.0.2"}}
+			}
+
+			// Act
+			out, err := e.Wrap(req, []byte(`{"action":"opened"}`))
+
+			// Assert
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPayload, string(out))
+		})
+	}
+}
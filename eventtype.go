@@ -0,0 +1,54 @@
+package ingester
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultEventTypeTemplate = "{event}"
+	defaultEventTypeFallback = "{provider}.event"
+)
+
+// eventTypeExtractor derives the Convoy event type of an inbound webhook.
+type eventTypeExtractor struct {
+	provider string
+	config   *EventTypeConfig
+}
+
+// Extract reads the event type from the configured header or JSON path and
+// renders it with the template. It returns the fallback when neither holds
+// a value.
+func (e *eventTypeExtractor) Extract(r *http.Request, payload []byte) string {
+	if e.config == nil {
+		return e.render(defaultEventTypeFallback, "")
+	}
+
+	var event string
+	if len(e.config.Header) != 0 {
+		event = strings.TrimSpace(r.Header.Get(e.config.Header))
+	}
+
+	if len(event) == 0 && len(e.config.Path) != 0 {
+		event, _ = lookupPathString(payload, e.config.Path)
+	}
+
+	if len(strings.TrimSpace(event)) == 0 {
+		fallback := e.config.Fallback
+		if len(fallback) == 0 {
+			fallback = defaultEventTypeFallback
+		}
+		return e.render(fallback, "")
+	}
+
+	template := e.config.Template
+	if len(template) == 0 {
+		template = defaultEventTypeTemplate
+	}
+
+	return e.render(template, event)
+}
+
+func (e *eventTypeExtractor) render(template, event string) string {
+	return strings.NewReplacer("{provider}", e.provider, "{event}", event).Replace(template)
+}
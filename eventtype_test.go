@@ -0,0 +1,75 @@
+package ingester
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EventTypeExtractor_Extract(t *testing.T) {
+	tests := map[string]struct {
+		config            *EventTypeConfig
+		payload           string
+		headers           map[string]string
+		expectedEventType string
+	}{
+		"no_config": {
+			config:            nil,
+			payload:           `{"event": "charge.success"}`,
+			expectedEventType: "paystack.event",
+		},
+		"body_path": {
+			config:            &EventTypeConfig{Path: "$.event"},
+			payload:           `{"event": "charge.success"}`,
+			expectedEventType: "charge.success",
+		},
+		"nested_body_path": {
+			config:            &EventTypeConfig{Path: "$.data.items[1].type"},
+			payload:           `{"data": {"items": [{"type": "a"}, {"type": "b"}]}}`,
+			expectedEventType: "b",
+		},
+		"header": {
+			config:            &EventTypeConfig{Header: "X-GitHub-Event", Path: "$.action"},
+			payload:           `{"action": "opened"}`,
+			headers:           map[string]string{"X-GitHub-Event": "pull_request"},
+			expectedEventType: "pull_request",
+		},
+		"template": {
+			config:            &EventTypeConfig{Path: "$.event", Template: "{provider}.{event}"},
+			payload:           `{"event": "charge.success"}`,
+			expectedEventType: "paystack.charge.success",
+		},
+		"default_fallback": {
+			config:            &EventTypeConfig{Path: "$.event"},
+			payload:           `not json`,
+			expectedEventType: "paystack.event",
+		},
+		"custom_fallback": {
+			config:            &EventTypeConfig{Path: "$.event", Fallback: "{provider}.unknown"},
+			payload:           `{"type": "charge.success"}`,
+			expectedEventType: "paystack.unknown",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			e := &eventTypeExtractor{provider: "paystack", config: tc.config}
+
+			req, err := http.NewRequest("POST", "URL", strings.NewReader(tc.payload))
+			require.NoError(t, err)
+
+			for k, v := range tc.headers {
+				req.Header.Add(k, v)
+			}
+
+			// Act
+			eventType := e.Extract(req, []byte(tc.payload))
+
+			// Assert
+			require.Equal(t, tc.expectedEventType, eventType)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+)
+
+// Convoy APIs events can be forwarded with.
+const (
+	// convoyAppsAPI creates the event for its app, Convoy's legacy model.
+	convoyAppsAPI = "apps"
+
+	// convoyEndpointAPI creates a project event for the endpoint with the
+	// event's app ID.
+	convoyEndpointAPI = "endpoint"
+
+	// convoyFanoutAPI creates a project event for every endpoint of the
+	// owner with the event's app ID.
+	convoyFanoutAPI = "fanout"
+
+	// convoyIngestAPI sends the event's data to an incoming source.
+	convoyIngestAPI = "ingest"
+)
+
+var (
+	ErrInvalidConvoyAPI = errors.New("Unknown Convoy API")
+	ErrMultipleTargets  = errors.New("Provider can't have both Convoy and HTTP targets")
+
+	// ErrSourceNotConfigured is permanent, the event's target lacks it.
+	ErrSourceNotConfigured = permanent(errors.New("Convoy source mask ID is not configured"))
+
+	// ErrUnsignedConvoyAPI is permanent: the apps and ingest APIs can't
+	// carry the ingester's signature headers, so events sent with them
+	// would go out unsigned.
+	ErrUnsignedConvoyAPI = permanent(errors.New("Convoy API can't carry ingester signatures, use the endpoint or fanout API"))
+)
+
+// Forwarder delivers a queued event to its destination. Forwarding stops
+// when ctx is done.
+type Forwarder interface {
+	Forward(ctx context.Context, req *convoyRequest) error
+}
+
+// BatchForwarder is a Forwarder whose destination also takes several
+// events in one request. The error applies to every event of the batch.
+type BatchForwarder interface {
+	Forwarder
+	ForwardBatch(ctx context.Context, reqs []*convoyRequest) error
+}
+
+// forwarderFor returns the forwarder of req's provider when it has an HTTP
+// target, or else the one for the provider's Convoy target, along with the
+// name of the target. Events of unknown providers go to the default
+// target. An API key missing from the environment is a transient error.
+func forwarderFor(req *convoyRequest) (Forwarder, string, error) {
+	p, _ := LookupProvider(req.Provider)
+	if p != nil && p.forwarder != nil {
+		var f Forwarder = p.forwarder
+		if p.forwarder.config.Batch {
+			f = &batchHTTPForwarder{p.forwarder}
+		}
+
+		target := "http:" + p.forwarder.config.URL
+		return targetGuards.Guard(target, f, &convoyAPI.config), target, nil
+	}
+
+	var t *convoyTarget
+	if p != nil {
+		t = p.ConvoyTarget()
+	}
+
+	c, err := convoyAPI.WithTarget(t)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if p != nil && p.convoy != nil && len(p.convoy.APIKey) != 0 {
+		c = c.WithAPIKey(p.convoy.APIKey)
+	}
+
+	if p != nil && p.convoyTimeout != 0 {
+		c = c.WithTimeout(p.convoyTimeout)
+	}
+
+	f, err := newConvoyForwarder(c, t)
+	if err != nil {
+		return nil, "", err
+	}
+
+	target := "convoy:" + c.config.URL + ":" + c.config.GroupID + ":" + c.config.ProjectID
+	if t != nil && t.API == convoyIngestAPI {
+		target += ":" + t.SourceMaskID
+	}
+
+	return targetGuards.Guard(target, f, &c.config), target, nil
+}
+
+// newConvoyForwarder returns the forwarder for the Convoy API of t, which
+// defaults to the apps API. Events are signed, so only APIs that carry
+// custom headers are allowed when the ingester has a signing key.
+func newConvoyForwarder(c *convoyClient, t *convoyTarget) (Forwarder, error) {
+	api := ""
+	if t != nil {
+		api = t.API
+	}
+
+	if eventSigner != nil && (api == "" || api == convoyAppsAPI || api == convoyIngestAPI) {
+		return nil, ErrUnsignedConvoyAPI
+	}
+
+	switch api {
+	case "", convoyAppsAPI:
+		return &appEventForwarder{c}, nil
+	case convoyEndpointAPI:
+		return &projectEventForwarder{client: c}, nil
+	case convoyFanoutAPI:
+		return &projectEventForwarder{client: c, fanout: true}, nil
+	case convoyIngestAPI:
+		if len(t.SourceMaskID) == 0 {
+			return nil, ErrSourceNotConfigured
+		}
+		return &ingestForwarder{client: c, maskID: t.SourceMaskID}, nil
+	default:
+		return nil, permanent(ErrInvalidConvoyAPI)
+	}
+}
+
+// appEventForwarder forwards with Convoy's legacy apps API.
+type appEventForwarder struct {
+	client *convoyClient
+}
+
+func (f *appEventForwarder) Forward(ctx context.Context, req *convoyRequest) error {
+	return f.client.CreateAppEvent(ctx, &appEvent{EventRequest: req.Data, IdempotencyKey: req.IdempotencyKey})
+}
+
+// projectEventForwarder forwards with Convoy's project-scoped events API.
+type projectEventForwarder struct {
+	client *convoyClient
+	fanout bool
+}
+
+func (f *projectEventForwarder) Forward(ctx context.Context, req *convoyRequest) error {
+	event := &projectEvent{
+		EventType:      req.Data.Event,
+		Data:           req.Data.Data,
+		IdempotencyKey: req.IdempotencyKey,
+		CustomHeaders:  signatureHeaders(req.Data.Data),
+	}
+
+	if f.fanout {
+		event.OwnerID = req.Data.AppID
+	} else {
+		event.EndpointID = req.Data.AppID
+	}
+
+	return f.client.CreateProjectEvent(ctx, event, f.fanout)
+}
+
+// ingestForwarder forwards to a Convoy incoming source.
+type ingestForwarder struct {
+	client *convoyClient
+	maskID string
+}
+
+func (f *ingestForwarder) Forward(ctx context.Context, req *convoyRequest) error {
+	return f.client.Ingest(ctx, f.maskID, req.Data.Data, req.IdempotencyKey)
+}
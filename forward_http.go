@@ -0,0 +1,218 @@
+package ingester
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	convoyModels "github.com/frain-dev/convoy-go/models"
+)
+
+const defaultRelaySignatureHeader = "X-Relay-Signature"
+
+var ErrInvalidHTTPTarget = errors.New("HTTP target needs a URL")
+
+// httpForwarder relays events to an HTTP endpoint.
+type httpForwarder struct {
+	config  *HTTPTargetConfig
+	client  *http.Client
+	timeout time.Duration
+
+	// hash is nil when the body isn't signed.
+	hash func() hash.Hash
+}
+
+func newHTTPForwarder(c *HTTPTargetConfig) (*httpForwarder, error) {
+	if len(c.URL) == 0 {
+		return nil, ErrInvalidHTTPTarget
+	}
+
+	timeout := defaultConvoyTimeout
+	if len(c.Timeout) != 0 {
+		var err error
+		if timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &httpForwarder{config: c, client: &http.Client{}, timeout: timeout}
+	if c.Signature != nil {
+		algo := c.Signature.Hash
+		if len(algo) == 0 {
+			algo = "SHA256"
+		}
+
+		h, err := getHashFunction(algo)
+		if err != nil {
+			return nil, err
+		}
+		f.hash = h
+	}
+
+	return f, nil
+}
+
+func (f *httpForwarder) Forward(ctx context.Context, req *convoyRequest) error {
+	return f.send(ctx, req, req.Data.Data)
+}
+
+// send delivers body, with headers rendered for req. The request is
+// cancelled when ctx is done or the target's timeout passes.
+func (f *httpForwarder) send(ctx context.Context, req *convoyRequest, body []byte) error {
+	method := f.config.Method
+	if len(method) == 0 {
+		method = http.MethodPost
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, method, f.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+
+	r.Header.Set("Content-Type", "application/json")
+	for name, tmpl := range f.config.Headers {
+		r.Header.Set(name, expandHeader(tmpl, req))
+	}
+
+	for name, value := range signatureHeaders(body) {
+		r.Header.Set(name, value)
+	}
+
+	if f.hash != nil {
+		header := f.config.Signature.Header
+		if len(header) == 0 {
+			header = defaultRelaySignatureHeader
+		}
+		r.Header.Set(header, f.sign(body))
+	}
+
+	resp, err := f.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	return &statusError{StatusCode: resp.StatusCode, Message: string(b)}
+}
+
+// batchEvent is an event in a batch sent to an HTTP target.
+type batchEvent struct {
+	Provider       string          `json:"provider,omitempty"`
+	EventType      string          `json:"event_type"`
+	AppID          string          `json:"app_id"`
+	RequestID      string          `json:"request_id,omitempty"`
+	IdempotencyKey string          `json:"idempotency_key,omitempty"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// batchHTTPForwarder is an httpForwarder whose endpoint takes batches.
+type batchHTTPForwarder struct {
+	*httpForwarder
+}
+
+func (f *batchHTTPForwarder) ForwardBatch(ctx context.Context, reqs []*convoyRequest) error {
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	events := make([]batchEvent, len(reqs))
+	for i, req := range reqs {
+		events[i] = batchEvent{
+			Provider:       req.Provider,
+			EventType:      req.Data.Event,
+			AppID:          req.Data.AppID,
+			RequestID:      req.RequestID,
+			IdempotencyKey: req.IdempotencyKey,
+			Data:           req.Data.Data,
+		}
+	}
+
+	body, err := json.Marshal(events)
+	if err != nil {
+		return permanent(err)
+	}
+
+	return f.send(ctx, commonRequest(reqs), body)
+}
+
+// commonRequest returns the fields shared by every request of a batch, so
+// header templates render empty for fields that differ between events.
+func commonRequest(reqs []*convoyRequest) *convoyRequest {
+	c := &convoyRequest{
+		Data: convoyModels.EventRequest{
+			AppID: reqs[0].Data.AppID,
+			Event: reqs[0].Data.Event,
+		},
+		Provider:       reqs[0].Provider,
+		RequestID:      reqs[0].RequestID,
+		IdempotencyKey: reqs[0].IdempotencyKey,
+	}
+
+	for _, req := range reqs[1:] {
+		fields := map[*string]string{
+			&c.Data.AppID:     req.Data.AppID,
+			&c.Data.Event:     req.Data.Event,
+			&c.Provider:       req.Provider,
+			&c.RequestID:      req.RequestID,
+			&c.IdempotencyKey: req.IdempotencyKey,
+		}
+		for field, v := range fields {
+			if *field != v {
+				*field = ""
+			}
+		}
+	}
+
+	return c
+}
+
+func (f *httpForwarder) sign(body []byte) string {
+	mac := hmac.New(f.hash, []byte(f.config.Signature.Secret))
+	mac.Write(body)
+	sum := mac.Sum(nil)
+
+	if f.config.Signature.Encoding == "base64" {
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum)
+}
+
+// expandHeader renders a header template for req.
+func expandHeader(tmpl string, req *convoyRequest) string {
+	return expandPlaceholders(tmpl, func(field string) (string, bool) {
+		switch {
+		case field == "provider":
+			return req.Provider, true
+		case field == "event":
+			return req.Data.Event, true
+		case field == "app_id":
+			return req.Data.AppID, true
+		case field == "idempotency_key":
+			return req.IdempotencyKey, true
+		case field == "request_id":
+			return req.RequestID, true
+		case strings.HasPrefix(field, "env:"):
+			return os.Getenv(strings.TrimPrefix(field, "env:")), true
+		default:
+			return "", false
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package ingester
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	convoyModels "github.com/frain-dev/convoy-go/models"
+	"github.com/frain-dev/convoy-ingester/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HTTPForwarder_Forward(t *testing.T) {
+	t.Setenv("RELAY_TOKEN", "relay-token")
+
+	tests := map[string]struct {
+		config            HTTPTargetConfig
+		status            int
+		expectedMethod    string
+		expectedHeaders   map[string]string
+		expectedSigned    bool
+		expectedErr       bool
+		expectedPermanent bool
+	}{
+		"defaults": {
+			config:         HTTPTargetConfig{},
+			status:         http.StatusOK,
+			expectedMethod: http.MethodPost,
+		},
+		"method and header templates": {
+			config: HTTPTargetConfig{
+				Method: http.MethodPut,
+				Headers: map[string]string{
+					"Authorization":   "Bearer {env:RELAY_TOKEN}",
+					"X-Event":         "{provider}.{event}",
+					"Idempotency-Key": "{idempotency_key}",
+				},
+			},
+			status:         http.StatusAccepted,
+			expectedMethod: http.MethodPut,
+			expectedHeaders: map[string]string{
+				"Authorization":   "Bearer relay-token",
+				"X-Event":         "paystack.charge.success",
+				"Idempotency-Key": "paystack:302961",
+			},
+		},
+		"signed": {
+			config: HTTPTargetConfig{
+				Signature: &HTTPSignatureConfig{Secret: "relay-secret"},
+			},
+			status:         http.StatusOK,
+			expectedMethod: http.MethodPost,
+			expectedSigned: true,
+		},
+		"rejected": {
+			config:            HTTPTargetConfig{},
+			status:            http.StatusUnprocessableEntity,
+			expectedMethod:    http.MethodPost,
+			expectedErr:       true,
+			expectedPermanent: true,
+		},
+		"unavailable": {
+			config:         HTTPTargetConfig{},
+			status:         http.StatusServiceUnavailable,
+			expectedMethod: http.MethodPost,
+			expectedErr:    true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			var r *http.Request
+			var body []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				r = req
+				body, _ = ioutil.ReadAll(req.Body)
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			tc.config.URL = srv.URL
+			f, err := newHTTPForwarder(&tc.config)
+			require.NoError(t, err)
+
+			req := &convoyRequest{
+				Data: convoyModels.EventRequest{
+					AppID: "app-id",
+					Event: "charge.success",
+					Data:  []byte(`{"id":302961}`),
+				},
+				Provider:       "paystack",
+				IdempotencyKey: "paystack:302961",
+			}
+
+			// Act
+			err = f.Forward(context.Background(), req)
+
+			// Assert
+			if tc.expectedErr {
+				require.Error(t, err)
+				require.Equal(t, tc.expectedPermanent, isPermanent(err))
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, tc.expectedMethod, r.Method)
+			require.Equal(t, `{"id":302961}`, string(body))
+			for name, value := range tc.expectedHeaders {
+				require.Equal(t, value, r.Header.Get(name))
+			}
+
+			if tc.expectedSigned {
+				mac := hmac.New(sha256.New, []byte("relay-secret"))
+				mac.Write(body)
+				require.Equal(t, hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Relay-Signature"))
+			}
+		})
+	}
+}
+
+func Test_HTTPForwarder_IngesterSignature(t *testing.T) {
+	// Arrange
+	var r *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r = req
+		body, _ = ioutil.ReadAll(req.Body)
+	}))
+	defer srv.Close()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	prev := eventSigner
+	eventSigner = &signature.Ed25519Signer{PrivateKey: priv}
+	defer func() { eventSigner = prev }()
+
+	f, err := newHTTPForwarder(&HTTPTargetConfig{URL: srv.URL})
+	require.NoError(t, err)
+
+	// Act
+	err = f.Forward(context.Background(), &convoyRequest{Data: convoyModels.EventRequest{Data: []byte(`{"id":302961}`)}})
+
+	// Assert
+	require.NoError(t, err)
+	v := &signature.Verifier{PublicKey: pub}
+	require.NoError(t, v.Verify(body, r.Header.Get(signature.HeaderSignature), r.Header.Get(signature.HeaderTimestamp)))
+}
+
+func Test_BatchHTTPForwarder_Headers(t *testing.T) {
+	// Arrange
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+	}))
+	defer srv.Close()
+
+	f, err := newHTTPForwarder(&HTTPTargetConfig{
+		URL: srv.URL,
+		Headers: map[string]string{
+			"X-Provider":      "{provider}",
+			"X-App":           "{app_id}",
+			"Idempotency-Key": "{idempotency_key}",
+		},
+		Batch: true,
+	})
+	require.NoError(t, err)
+
+	reqs := []*convoyRequest{
+		{Data: convoyModels.EventRequest{AppID: "app-1", Event: "charge.success"}, Provider: "paystack", IdempotencyKey: "paystack:1"},
+		{Data: convoyModels.EventRequest{AppID: "app-2", Event: "charge.success"}, Provider: "paystack", IdempotencyKey: "paystack:2"},
+	}
+
+	// Act
+	err = (&batchHTTPForwarder{f}).ForwardBatch(context.Background(), reqs)
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, "paystack", headers.Get("X-Provider"))
+	require.Empty(t, headers.Get("X-App"))
+	require.Empty(t, headers.Get("Idempotency-Key"))
+}
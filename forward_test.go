@@ -0,0 +1,201 @@
+package ingester
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	convoyModels "github.com/frain-dev/convoy-go/models"
+	"github.com/frain-dev/convoy-ingester/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ConvoyForwarder(t *testing.T) {
+	tests := map[string]struct {
+		target       *convoyTarget
+		expectedURI  string
+		expectedBody string
+		expectedAuth string
+		expectedKey  string
+	}{
+		"apps": {
+			target:       nil,
+			expectedURI:  "/api/v1/events?groupID=project-id",
+			expectedBody: `{"app_id":"app-id","event_type":"charge.success","data":{"id":302961},"idempotency_key":"paystack:302961"}`,
+			expectedAuth: "Bearer api-key",
+		},
+		"endpoint": {
+			target:       &convoyTarget{API: "endpoint"},
+			expectedURI:  "/api/v1/projects/project-id/events",
+			expectedBody: `{"endpoint_id":"app-id","event_type":"charge.success","data":{"id":302961},"idempotency_key":"paystack:302961"}`,
+			expectedAuth: "Bearer api-key",
+		},
+		"fanout": {
+			target:       &convoyTarget{API: "fanout"},
+			expectedURI:  "/api/v1/projects/project-id/events/fanout",
+			expectedBody: `{"owner_id":"app-id","event_type":"charge.success","data":{"id":302961},"idempotency_key":"paystack:302961"}`,
+			expectedAuth: "Bearer api-key",
+		},
+		"ingest": {
+			target:       &convoyTarget{API: "ingest", SourceMaskID: "mask-id"},
+			expectedURI:  "/ingest/mask-id",
+			expectedBody: `{"id":302961}`,
+			expectedAuth: "",
+			expectedKey:  "paystack:302961",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			var uri, body, auth, key string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				uri, body, auth = r.URL.RequestURI(), string(b), r.Header.Get("Authorization")
+				key = r.Header.Get(convoyIdempotencyKeyHeader)
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer srv.Close()
+
+			c := newConvoyClient(&ConvoyConfig{URL: srv.URL + "/api/v1", ProjectID: "project-id", APIKey: "api-key"})
+			req := &convoyRequest{
+				Data: convoyModels.EventRequest{
+					AppID: "app-id",
+					Event: "charge.success",
+					Data:  []byte(`{"id":302961}`),
+				},
+				IdempotencyKey: "paystack:302961",
+			}
+
+			f, err := newConvoyForwarder(c, tc.target)
+			require.NoError(t, err)
+
+			// Act
+			err = f.Forward(context.Background(), req)
+
+			// Assert
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedURI, uri)
+			require.JSONEq(t, tc.expectedBody, body)
+			require.Equal(t, tc.expectedAuth, auth)
+			require.Equal(t, tc.expectedKey, key)
+		})
+	}
+}
+
+func Test_NewConvoyForwarder_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		target      *convoyTarget
+		expectedErr error
+	}{
+		"unknown api": {
+			target:      &convoyTarget{API: "webhooks"},
+			expectedErr: ErrInvalidConvoyAPI,
+		},
+		"ingest without source": {
+			target:      &convoyTarget{API: "ingest"},
+			expectedErr: ErrSourceNotConfigured,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := newConvoyForwarder(nil, tc.target)
+			require.ErrorIs(t, err, tc.expectedErr)
+			require.True(t, isPermanent(err))
+		})
+	}
+}
+
+func Test_ForwarderFor_ProviderTarget(t *testing.T) {
+	t.Setenv("CONVOY_TENANT_API_KEY", "tenant-key")
+
+	prevStore, prevAPI := providerStore, convoyAPI
+	providerStore = ProviderStore{"tenant": {
+		Name:   "tenant",
+		convoy: &ConvoyTargetConfig{URL: "https://tenant.example.com/api/v1", GroupID: "tenant-group", APIKeyEnv: "CONVOY_TENANT_API_KEY"},
+	}}
+	convoyAPI = newConvoyClient(&ConvoyConfig{URL: "https://convoy.example.com/api/v1", GroupID: "group-id", APIKey: "api-key"})
+	defer func() { providerStore, convoyAPI = prevStore, prevAPI }()
+
+	tests := map[string]struct {
+		provider       string
+		expectedTarget string
+	}{
+		"provider target": {
+			provider:       "tenant",
+			expectedTarget: "convoy:https://tenant.example.com/api/v1:tenant-group:",
+		},
+		"unknown provider": {
+			provider:       "unknown",
+			expectedTarget: "convoy:https://convoy.example.com/api/v1:group-id:",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			req := &convoyRequest{}
+			err := req.FromMessage(pubSubMessage{
+				Data: []byte(`{"id":302961}`),
+				Attributes: map[string]string{
+					"provider":           tc.provider,
+					"app_id":             "app-id",
+					"convoy_url":         "https://attacker.example.com",
+					"convoy_api_key_env": "CONVOY_TENANT_API_KEY",
+				},
+			})
+			require.NoError(t, err)
+
+			// Act
+			_, target, err := forwarderFor(req)
+
+			// Assert
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedTarget, target)
+		})
+	}
+}
+
+func Test_NewConvoyForwarder_Signed(t *testing.T) {
+	prev := eventSigner
+	eventSigner = &signature.HMACSigner{Secret: []byte("signing-secret")}
+	defer func() { eventSigner = prev }()
+
+	tests := map[string]struct {
+		target      *convoyTarget
+		expectedErr error
+	}{
+		"apps": {
+			target:      nil,
+			expectedErr: ErrUnsignedConvoyAPI,
+		},
+		"ingest": {
+			target:      &convoyTarget{API: "ingest", SourceMaskID: "mask-id"},
+			expectedErr: ErrUnsignedConvoyAPI,
+		},
+		"endpoint": {
+			target:      &convoyTarget{API: "endpoint"},
+			expectedErr: nil,
+		},
+		"fanout": {
+			target:      &convoyTarget{API: "fanout"},
+			expectedErr: nil,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := newConvoyForwarder(nil, tc.target)
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, tc.expectedErr)
+			require.True(t, isPermanent(err))
+		})
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/pubsub"
-	convoy "github.com/frain-dev/convoy-go"
 	convoyModels "github.com/frain-dev/convoy-go/models"
+	"github.com/frain-dev/convoy-ingester/signature"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -25,19 +28,93 @@ var (
 	// client is a global Pub/Sub client, initialized once per instance.
 	client *pubsub.Client
 
+	// publishTopic is the handle events are published with. Ordering is
+	// enabled so providers can set ordering keys.
+	publishTopic *pubsub.Topic
+
 	// Configuration Storage
 	configStore *Configuration
 
 	// Configuration Environment Variable
 	CONFIG_ENV = "CONVOY_INGESTER_CONFIG"
 
+	// Master Key Environment Variable
+	MASTER_KEY_ENV = "CONVOY_INGESTER_MASTER_KEY"
+
+	// keyWrapper opens encrypted configuration values.
+	keyWrapper KeyWrapper
+
 	// Providers Store
 	providerStore = make(ProviderStore)
+
+	// Redis URL Environment Variable, e.g. redis://:password@host:6379/0
+	DEDUPE_REDIS_URL_ENV = "CONVOY_INGESTER_REDIS_URL"
+
+	// dedupeStore remembers idempotency keys of published events.
+	dedupeStore DedupeStore = newMemoryDedupeStore()
+
+	// Convoy Environment Variables, read by PushToConvoy.
+	CONVOY_URL_ENV            = "CONVOY_URL"
+	CONVOY_GROUP_ID_ENV       = "CONVOY_GROUP_ID"
+	CONVOY_API_KEY_ENV        = "CONVOY_API_KEY"
+	CONVOY_API_USERNAME_ENV   = "CONVOY_API_USERNAME"
+	CONVOY_API_PASSWORD_ENV   = "CONVOY_API_PASSWORD"
+	CONVOY_TIMEOUT_ENV        = "CONVOY_TIMEOUT"
+	CONVOY_MAX_IDLE_CONNS_ENV = "CONVOY_MAX_IDLE_CONNS"
+
+	// Limits of each target, see ConvoyConfig.
+	CONVOY_RATE_LIMIT_ENV        = "CONVOY_RATE_LIMIT"
+	CONVOY_RATE_BURST_ENV        = "CONVOY_RATE_BURST"
+	CONVOY_BREAKER_THRESHOLD_ENV = "CONVOY_BREAKER_THRESHOLD"
+	CONVOY_BREAKER_COOLDOWN_ENV  = "CONVOY_BREAKER_COOLDOWN"
+
+	// convoyAPI is the Convoy client, created once per instance so its
+	// connections are reused between invocations. It is loaded from the
+	// environment in prod and has the defaults elsewhere.
+	convoyAPI = newConvoyClient(&ConvoyConfig{
+		Timeout:      defaultConvoyTimeout,
+		MaxIdleConns: defaultConvoyMaxIdleConns,
+
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
+	})
+
+	// Signing Environment Variables. Forwarded events are signed with the
+	// base64 Ed25519 key, or else with the HMAC secret.
+	SIGNING_KEY_ENV    = "CONVOY_INGESTER_SIGNING_KEY"
+	SIGNING_SECRET_ENV = "CONVOY_INGESTER_SIGNING_SECRET"
+
+	// eventSigner signs forwarded events, or is nil when they aren't.
+	eventSigner signature.Signer
+
+	// Dead Letter Environment Variables. Events that can't be delivered
+	// are published to the topic, or written to the directory by the
+	// worker.
+	DEAD_LETTER_DIR_ENV   = "CONVOY_INGESTER_DEAD_LETTER_DIR"
+	DEAD_LETTER_TOPIC_ENV = "CONVOY_INGESTER_DEAD_LETTER_TOPIC"
+
+	// FUNCTION_TARGET_ENV is set by Cloud Functions to the function's
+	// entry point.
+	FUNCTION_TARGET_ENV = "FUNCTION_TARGET"
+
+	// deadLetterSink keeps events PushToConvoy can't deliver.
+	deadLetterSink DeadLetterSink = logDeadLetterSink{}
+
+	// Metrics Environment Variable, holding the bearer token of /metrics.
+	METRICS_TOKEN_ENV = "CONVOY_INGESTER_METRICS_TOKEN"
+
+	// Worker Environment Variables, read by LoadWorkerConfig.
+	WORKER_CONCURRENCY_ENV = "CONVOY_INGESTER_WORKER_CONCURRENCY"
+	WORKER_BATCH_SIZE_ENV  = "CONVOY_INGESTER_WORKER_BATCH_SIZE"
+	WORKER_BATCH_WAIT_ENV  = "CONVOY_INGESTER_WORKER_BATCH_WAIT"
+
+	WORKER_DRAIN_TIMEOUT_ENV = "CONVOY_INGESTER_WORKER_DRAIN_TIMEOUT"
 )
 
 func init() {
 	// err is pre-declared to avoid shadowing client.
 	var err error
+	var convoyConfig *ConvoyConfig
 
 	// Set environment to prevent the init function from running in our tests.
 	env := os.Getenv("ENV")
@@ -45,16 +122,54 @@ func init() {
 	// client is initialized with context.Background() because it should
 	// persist between function invocations.
 	if env == "prod" {
+		convoyConfig, err = LoadConvoyConfig()
+		if err != nil {
+			log.Fatalf("Failed to load Convoy config: %v", err)
+		}
+		convoyAPI = newConvoyClient(convoyConfig)
+
+		if eventSigner, err = newSignerFromEnv(); err != nil {
+			log.Fatalf("Failed to load signing key: %v", err)
+		}
+
 		client, err = pubsub.NewClient(context.Background(), projectID)
 		if err != nil {
 			log.Fatalf("pubsub.NewClient: %v", err)
 		}
 
+		publishTopic = client.Topic(topic)
+		publishTopic.EnableMessageOrdering = true
+
+		keyWrapper, err = NewKeyWrapperFromEnv(MASTER_KEY_ENV)
+		if err != nil {
+			log.Fatalf("Failed to load master key: %v", err)
+		}
+
 		// Setup configStore
 		if err = LoadConfig(CONFIG_ENV); err != nil {
 			log.Fatalf("Failed to load config: %v", err)
 		}
 
+		if redisURL := os.Getenv(DEDUPE_REDIS_URL_ENV); len(redisURL) != 0 {
+			if dedupeStore, err = newRedisDedupeStore(redisURL); err != nil {
+				log.Fatalf("Failed to setup dedupe store: %v", err)
+			}
+		}
+
+		if dir := os.Getenv(DEAD_LETTER_DIR_ENV); len(dir) != 0 {
+			// Cloud Functions set FUNCTION_TARGET, and lose their files
+			// with the instance.
+			if len(os.Getenv(FUNCTION_TARGET_ENV)) != 0 {
+				log.Fatalf("%s is only supported by the worker, set %s instead", DEAD_LETTER_DIR_ENV, DEAD_LETTER_TOPIC_ENV)
+			}
+
+			if deadLetterSink, err = NewDirectoryDeadLetterStore(dir); err != nil {
+				log.Fatalf("Failed to setup dead letter store: %v", err)
+			}
+		} else if t := os.Getenv(DEAD_LETTER_TOPIC_ENV); len(t) != 0 {
+			deadLetterSink = &topicDeadLetterSink{topic: client.Topic(t)}
+		}
+
 		// TODO(subomi): Initialize providers registry once per instance.
 		if err = LoadProviderStore(); err != nil {
 			log.Fatalf("Failed to setup provider store: %v", err)
@@ -71,36 +186,104 @@ func WebhookEndpoint(w http.ResponseWriter, r *http.Request) {
 
 		// TODO(subomi): Use middleware to set provider in the request context.
 		v1Router.Post("/webhooks/{provider}", WebhooksHandler)
+		v1Router.Get("/webhooks/{provider}", WebhooksHandler)
 	})
 
+	router.Get("/metrics", metricsHandler)
+
 	// Serve Request.
 	router.ServeHTTP(w, r)
 }
 
 // PushToConvoy is a Pub/Sub Triggered Function to push events to Convoy.
+// Messages that fail permanently are acknowledged and dead-lettered; an
+// error is only returned for transient failures, so Pub/Sub retries them.
 func PushToConvoy(ctx context.Context, m pubSubMessage) error {
-	payload := []byte(string(m.Data))
-	req := &convoyRequest{}
-	if err := req.FromBytes(payload); err != nil {
-		log.Printf("Failed to parse payload - %v", err)
+	d, err := prepareDelivery(m)
+	if d == nil {
 		return err
 	}
 
-	// Actual push to Convoy.
-	convoyClient := convoy.New()
-	_, err := convoyClient.CreateAppEvent(&req.Data)
+	attempts, err := withRetry(ctx, func(ctx context.Context) error {
+		return d.forwarder.Forward(ctx, d.req)
+	})
+
+	return d.finish(attempts, err)
+}
 
-	if err != nil {
-		return errors.New(fmt.Sprintf("Server Error: Failed to send event to Convoy - %+v", err))
+// deadLetter hands d to the dead-letter sink, so its message is
+// acknowledged. The message is retried if the sink fails too.
+func deadLetter(d *DeadLetter) error {
+	if err := deadLetterSink.DeadLetter(d); err != nil {
+		return errors.New(fmt.Sprintf("Server Error: Failed to dead-letter event - %+v", err))
 	}
 
+	provider := d.Attributes[attrProvider]
+	if len(provider) == 0 {
+		provider = "unknown"
+	}
+	eventsDeadLettered.Add(provider, 1)
+
 	return nil
 }
 
+// respondHandshake answers r if it is a subscription challenge and reports
+// whether it was.
+func respondHandshake(w http.ResponseWriter, r *http.Request, h Handshaker, payload []byte) bool {
+	handled, err := h.Respond(w, r, payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidVerifyToken), errors.Is(err, ErrSNSCertURLNotAllowed):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		log.WithError(err).Error("Handshake failed")
+		return true
+	}
+
+	if handled {
+		log.Printf("Answered %s handshake", chi.URLParam(r, "provider"))
+	}
+
+	return handled
+}
+
+// releaseIdempotencyKey forgets key after a failed delivery, so the retry
+// isn't dropped as a duplicate.
+func releaseIdempotencyKey(key string) {
+	if len(key) == 0 {
+		return
+	}
+
+	if err := dedupeStore.Release(key); err != nil {
+		log.WithError(err).Errorf("Failed to release idempotency key %s", key)
+	}
+}
+
+// confirmIdempotencyKey keeps key for ttl after a successful delivery. If
+// it fails, the reservation still holds the key until it expires.
+func confirmIdempotencyKey(key string, ttl time.Duration) {
+	if len(key) == 0 {
+		return
+	}
+
+	if err := dedupeStore.Confirm(key, ttl); err != nil {
+		log.WithError(err).Errorf("Failed to confirm idempotency key %s", key)
+	}
+}
+
 // HTTP Handlers
 func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	receivedAt := time.Now()
+	requestID := uuid.New().String()
 	providerName := chi.URLParam(r, "provider")
-	provider := providerStore[providerName]
+	provider, err := LookupProvider(providerName)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		log.WithError(err).Errorf("Not Found: %s", providerName)
+		return
+	}
 
 	payload, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -109,6 +292,19 @@ func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Unsigned challenges are answered before verification.
+	if h := provider.Handshake(); h != nil && !h.Verified() {
+		if respondHandshake(w, r, h, payload) {
+			return
+		}
+	}
+
+	// Only handshakes are served over GET.
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
 	err = provider.VerifyRequest(r, payload)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -116,35 +312,179 @@ func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Push to Convoy.
-	event := fmt.Sprintf("%s.event", providerName)
-	req := &convoyRequest{
-		Data: convoyModels.EventRequest{
-			AppID: provider.AppID,
-			Event: event,
-			Data:  payload,
-		},
+	if h := provider.Handshake(); h != nil && h.Verified() {
+		if respondHandshake(w, r, h, payload) {
+			return
+		}
+	}
+
+	payload, err = provider.Unwrap(payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.WithError(err).Error("Bad Request: Could not unwrap payload")
+		return
 	}
 
-	data, err := req.ToBytes()
+	result, err := provider.RunScript(r, payload)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		log.WithError(err).Error("Bad Request: Failed to transform bytes")
+		log.WithError(err).Error("Bad Request: Script failed")
 		return
 	}
 
-	m := &pubsub.Message{
-		Data: data,
+	if result.Body != nil {
+		payload = result.Body
 	}
 
-	id, err := client.Topic(topic).Publish(r.Context(), m).Get(r.Context())
+	payload, err = normalizePayload(r.Header.Get("Content-Type"), payload)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		log.WithError(err).Error("Bad Request: Error publishing event")
+		log.WithError(err).Error("Bad Request: Could not normalize payload")
 		return
 	}
 
-	log.Printf("Event published, ID: %s\n", id)
+	// Push to Convoy.
+	event := result.EventType
+	if len(event) == 0 {
+		event = provider.EventType(r, payload)
+	}
+
+	wh := newWebhook(r, payload, event)
+	if !provider.Accepts(wh) {
+		eventsFiltered.Add(providerName, 1)
+		log.Printf("Filtered %s event %s", providerName, event)
+		w.Write([]byte("Event filtered"))
+		return
+	}
+
+	appIDs := provider.AppIDs(wh)
+	if len(appIDs) == 0 {
+		log.Warnf("No route for %s event %s", providerName, event)
+		w.Write([]byte("Event not routed"))
+		return
+	}
+
+	body, err := provider.Transform(payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.WithError(err).Error("Bad Request: Failed to transform payload")
+		return
+	}
+
+	body, err = provider.Wrap(r, body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.WithError(err).Error("Bad Request: Failed to wrap payload")
+		return
+	}
+
+	key := provider.IdempotencyKey(r, payload)
+	if len(key) != 0 {
+		ok, err := dedupeStore.Reserve(key, inFlightIdempotencyTTL)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.WithError(err).Error("Server Error: Failed to check idempotency key")
+			return
+		}
+
+		var confirmed bool
+		if !ok {
+			confirmed, err = dedupeStore.Confirmed(key)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				log.WithError(err).Error("Server Error: Failed to check idempotency key")
+				return
+			}
+		}
+
+		// The provider retries while the first copy is still being
+		// published, in case publishing it fails.
+		if !ok && !confirmed {
+			w.WriteHeader(http.StatusConflict)
+			log.Printf("Duplicate %s event %s is in flight, key: %s", providerName, event, key)
+			return
+		}
+
+		if !ok {
+			eventsDuplicate.Add(providerName, 1)
+			log.Printf("Duplicate %s event %s, key: %s", providerName, event, key)
+			w.Write([]byte("Duplicate event"))
+			return
+		}
+	}
+
+	for _, appID := range appIDs {
+		// Events routed to several apps remember each app they were
+		// published for, so a retry after a partial failure only
+		// publishes to the rest.
+		appKey := ""
+		if len(key) != 0 && len(appIDs) > 1 {
+			appKey = "publish:" + appID + ":" + key
+			published, err := dedupeStore.Confirmed(appKey)
+			if err != nil {
+				releaseIdempotencyKey(key)
+				w.WriteHeader(http.StatusInternalServerError)
+				log.WithError(err).Error("Server Error: Failed to check idempotency key")
+				return
+			}
+
+			if published {
+				continue
+			}
+		}
+
+		req := &convoyRequest{
+			Data: convoyModels.EventRequest{
+				AppID: appID,
+				Event: event,
+				Data:  body,
+			},
+			Provider:       providerName,
+			RequestID:      requestID,
+			IdempotencyKey: key,
+		}
+
+		data := body
+		if provider.Format == "cloudevents" {
+			// Each app's copy gets its own ID, so consumers deduping on
+			// source and ID keep every copy.
+			id := ""
+			if len(key) != 0 {
+				id = strings.TrimPrefix(key, providerName+":") + ":" + appID
+			}
+
+			data, err = req.ToCloudEvent(providerName, id, receivedAt)
+			if err != nil {
+				releaseIdempotencyKey(key)
+				w.WriteHeader(http.StatusBadRequest)
+				log.WithError(err).Error("Bad Request: Failed to build CloudEvent")
+				return
+			}
+		}
+
+		m := &pubsub.Message{
+			Data:        data,
+			Attributes:  req.Attributes(provider.Format),
+			OrderingKey: provider.OrderingKey(wh, appID),
+		}
+
+		id, err := publishTopic.Publish(r.Context(), m).Get(r.Context())
+		if err != nil {
+			if len(m.OrderingKey) != 0 {
+				publishTopic.ResumePublish(m.OrderingKey)
+			}
+
+			releaseIdempotencyKey(key)
+			w.WriteHeader(http.StatusBadRequest)
+			log.WithError(err).Error("Bad Request: Error publishing event")
+			return
+		}
+
+		confirmIdempotencyKey(appKey, provider.IdempotencyTTL())
+		eventsPublished.Add(providerName, 1)
+		log.Printf("Event published, ID: %s, request ID: %s\n", id, requestID)
+	}
 
+	confirmIdempotencyKey(key, provider.IdempotencyTTL())
 	w.Write([]byte("Event sent"))
 }
@@ -0,0 +1,287 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+
+	// maxRateWait is the longest an event waits for a token before it is
+	// handed back to Pub/Sub.
+	maxRateWait = time.Second
+)
+
+var (
+	ErrRateLimited = errors.New("Target rate limit reached")
+	ErrCircuitOpen = errors.New("Target circuit is open")
+)
+
+// pausedError is returned by a guard instead of calling its target. After
+// is how long until the target is expected to take events again.
+type pausedError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *pausedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pausedError) Unwrap() error {
+	return e.err
+}
+
+// isPaused reports whether err came from a guard rather than the target,
+// so retrying at once is pointless.
+func isPaused(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrCircuitOpen)
+}
+
+// pausedFor returns how long the target that paused err stays paused, and
+// whether it did.
+func pausedFor(err error) (time.Duration, bool) {
+	var e *pausedError
+	if !errors.As(err, &e) {
+		return 0, false
+	}
+
+	return e.after, true
+}
+
+// tokenBucket allows rate events per second, with bursts of up to burst.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), now: time.Now}
+}
+
+// Take reserves n tokens and returns how long to wait for the last of
+// them. It takes nothing and reports false when the wait would exceed
+// maxWait, so a refused batch doesn't hold back later events.
+func (b *tokenBucket) Take(n int, maxWait time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	var wait time.Duration
+	if missing := float64(n) - b.tokens; missing > 0 {
+		wait = time.Duration(missing / b.rate * float64(time.Second))
+	}
+
+	if wait > maxWait {
+		return wait, false
+	}
+
+	b.tokens -= float64(n)
+	return wait, true
+}
+
+// Capacity returns the most tokens a single Take waiting at most maxWait
+// can get, from a full bucket.
+func (b *tokenBucket) Capacity(maxWait time.Duration) int {
+	n := int(b.burst + b.rate*maxWait.Seconds())
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
+
+// circuitBreaker opens after threshold consecutive transient failures.
+// Once cooldown has passed a single probe is let through, which closes
+// the circuit if it succeeds.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow reports whether a request may be sent. When it may not, it
+// returns how long until the next probe; a whole cooldown while a probe
+// is in flight.
+func (b *circuitBreaker) Allow() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return 0, true
+	}
+
+	if b.probing {
+		return b.cooldown, false
+	}
+
+	if open := b.now().Sub(b.openedAt); open < b.cooldown {
+		return b.cooldown - open, false
+	}
+
+	b.probing = true
+	return 0, true
+}
+
+// Record counts the outcome of an allowed request. Permanent errors mean
+// the target is up, so they count as successes.
+func (b *circuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || isPermanent(err) {
+		b.failures, b.openedAt, b.probing = 0, time.Time{}, false
+		return
+	}
+
+	b.failures++
+	if b.probing || b.failures >= b.threshold {
+		b.openedAt, b.probing = b.now(), false
+	}
+}
+
+// Cancel gives up an allowed request without counting it, so another
+// probe can be sent if it was one.
+func (b *circuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// guardedForwarder rate limits a target's forwarder and stops calling it
+// while its circuit is open. Either is off when nil.
+type guardedForwarder struct {
+	next    Forwarder
+	bucket  *tokenBucket
+	breaker *circuitBreaker
+}
+
+func (g *guardedForwarder) Forward(ctx context.Context, req *convoyRequest) error {
+	return g.guard(ctx, 1, func() error {
+		return g.next.Forward(ctx, req)
+	})
+}
+
+// guard calls forward once n events are allowed through.
+func (g *guardedForwarder) guard(ctx context.Context, n int, forward func() error) error {
+	if g.bucket != nil {
+		wait, ok := g.bucket.Take(n, maxRateWait)
+		if !ok {
+			return &pausedError{err: ErrRateLimited, after: wait}
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	if g.breaker == nil {
+		return forward()
+	}
+
+	if wait, ok := g.breaker.Allow(); !ok {
+		return &pausedError{err: ErrCircuitOpen, after: wait}
+	}
+
+	// A request cut off by the caller's context says nothing about the
+	// target, so it only gives up the probe.
+	err := forward()
+	if ctx.Err() != nil {
+		g.breaker.Cancel()
+		return err
+	}
+
+	g.breaker.Record(err)
+	return err
+}
+
+// guardedBatchForwarder guards a BatchForwarder, taking a token for each
+// event of a batch.
+type guardedBatchForwarder struct {
+	*guardedForwarder
+}
+
+// maxBatch returns how many events f lets through in one batch, or 0 when
+// it doesn't limit them. Larger batches would never get enough tokens.
+func maxBatch(f Forwarder) int {
+	g, ok := f.(*guardedBatchForwarder)
+	if !ok || g.bucket == nil {
+		return 0
+	}
+
+	return g.bucket.Capacity(maxRateWait)
+}
+
+func (g *guardedBatchForwarder) ForwardBatch(ctx context.Context, reqs []*convoyRequest) error {
+	return g.guard(ctx, len(reqs), func() error {
+		return g.next.(BatchForwarder).ForwardBatch(ctx, reqs)
+	})
+}
+
+// guardRegistry keeps one bucket and breaker per target, shared by every
+// event sent to it.
+type guardRegistry struct {
+	mu     sync.Mutex
+	guards map[string]*guardedForwarder
+}
+
+var targetGuards = &guardRegistry{guards: map[string]*guardedForwarder{}}
+
+// Guard returns f guarded with the bucket and breaker of target, creating
+// them with c's limits on first use.
+func (r *guardRegistry) Guard(target string, f Forwarder, c *ConvoyConfig) Forwarder {
+	if c.RateLimit <= 0 && c.BreakerThreshold <= 0 {
+		return f
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.guards[target]
+	if !ok {
+		g = &guardedForwarder{}
+		if c.RateLimit > 0 {
+			g.bucket = newTokenBucket(c.RateLimit, c.RateBurst)
+		}
+
+		if c.BreakerThreshold > 0 {
+			g.breaker = newCircuitBreaker(c.BreakerThreshold, c.BreakerCooldown)
+		}
+		r.guards[target] = g
+	}
+
+	guarded := &guardedForwarder{next: f, bucket: g.bucket, breaker: g.breaker}
+	if _, ok := f.(BatchForwarder); ok {
+		return &guardedBatchForwarder{guarded}
+	}
+
+	return guarded
+}
@@ -0,0 +1,173 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeForwarder struct {
+	errs  []error
+	calls int
+}
+
+func (f *fakeForwarder) Forward(ctx context.Context, req *convoyRequest) error {
+	f.calls++
+	if len(f.errs) == 0 {
+		return nil
+	}
+
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func Test_TokenBucket_Take(t *testing.T) {
+	// Arrange
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := newTokenBucket(2, 2)
+	b.now = func() time.Time { return now }
+
+	// Act & Assert: the burst is spent at once.
+	for i := 0; i < 2; i++ {
+		wait, ok := b.Take(1, 0)
+		require.True(t, ok)
+		require.Zero(t, wait)
+	}
+
+	// The next token is 500ms away.
+	wait, ok := b.Take(1, 0)
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	wait, ok = b.Take(1, time.Second)
+	require.True(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	// Refills at the rate, up to the burst.
+	now = now.Add(10 * time.Second)
+	_, ok = b.Take(1, 0)
+	require.True(t, ok)
+	_, ok = b.Take(1, 0)
+	require.True(t, ok)
+	_, ok = b.Take(1, 0)
+	require.False(t, ok)
+}
+
+func Test_TokenBucket_TakeBatch(t *testing.T) {
+	// Arrange
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := newTokenBucket(5, 1)
+	b.now = func() time.Time { return now }
+
+	// Act & Assert: a batch too big to wait for takes nothing.
+	wait, ok := b.Take(20, maxRateWait)
+	require.False(t, ok)
+	require.Equal(t, 3800*time.Millisecond, wait)
+
+	wait, ok = b.Take(1, 0)
+	require.True(t, ok)
+	require.Zero(t, wait)
+
+	// The biggest batch that can pass is the burst and a second of rate.
+	now = now.Add(time.Second)
+	require.Equal(t, 6, b.Capacity(maxRateWait))
+	wait, ok = b.Take(6, maxRateWait)
+	require.True(t, ok)
+	require.Equal(t, time.Second, wait)
+}
+
+func Test_CircuitBreaker(t *testing.T) {
+	// Arrange
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	unavailable := &statusError{StatusCode: http.StatusServiceUnavailable}
+	next := &fakeForwarder{errs: []error{unavailable, unavailable, unavailable, nil}}
+
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+	g := &guardedForwarder{next: next, breaker: b}
+
+	// Act & Assert: two failures open the circuit.
+	require.Equal(t, unavailable, g.Forward(context.Background(), &convoyRequest{}))
+	require.Equal(t, unavailable, g.Forward(context.Background(), &convoyRequest{}))
+	require.ErrorIs(t, g.Forward(context.Background(), &convoyRequest{}), ErrCircuitOpen)
+	require.Equal(t, 2, next.calls)
+
+	// Events are paused for the rest of the cooldown.
+	now = now.Add(20 * time.Second)
+	after, ok := pausedFor(g.Forward(context.Background(), &convoyRequest{}))
+	require.True(t, ok)
+	require.Equal(t, 40*time.Second, after)
+
+	// A failed probe after the cooldown opens it again.
+	now = now.Add(time.Minute)
+	require.Equal(t, unavailable, g.Forward(context.Background(), &convoyRequest{}))
+	require.ErrorIs(t, g.Forward(context.Background(), &convoyRequest{}), ErrCircuitOpen)
+
+	// A successful probe closes it.
+	now = now.Add(time.Minute)
+	require.NoError(t, g.Forward(context.Background(), &convoyRequest{}))
+	require.NoError(t, g.Forward(context.Background(), &convoyRequest{}))
+	require.Equal(t, 5, next.calls)
+}
+
+func Test_CircuitBreaker_PermanentErrors(t *testing.T) {
+	rejected := &statusError{StatusCode: http.StatusBadRequest}
+	next := &fakeForwarder{errs: []error{rejected, rejected, rejected}}
+	g := &guardedForwarder{next: next, breaker: newCircuitBreaker(2, time.Minute)}
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, rejected, g.Forward(context.Background(), &convoyRequest{}))
+	}
+	require.Equal(t, 3, next.calls)
+}
+
+func Test_CircuitBreaker_CallerCancelled(t *testing.T) {
+	// Arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	next := &fakeForwarder{errs: []error{context.Canceled, context.Canceled}}
+	b := newCircuitBreaker(1, time.Minute)
+	g := &guardedForwarder{next: next, breaker: b}
+
+	// Act
+	for i := 0; i < 2; i++ {
+		require.ErrorIs(t, g.Forward(ctx, &convoyRequest{}), context.Canceled)
+	}
+
+	// Assert: cancelled requests don't open the circuit.
+	require.NoError(t, g.Forward(context.Background(), &convoyRequest{}))
+	require.Equal(t, 3, next.calls)
+}
+
+func Test_WithRetry_Paused(t *testing.T) {
+	prev := sleep
+	sleep = func(context.Context, time.Duration) error { return nil }
+	defer func() { sleep = prev }()
+
+	calls := 0
+	attempts, err := withRetry(context.Background(), func(context.Context) error {
+		calls++
+		return ErrCircuitOpen
+	})
+
+	require.True(t, errors.Is(err, ErrCircuitOpen))
+	require.False(t, isPermanent(err))
+	require.Len(t, attempts, 1)
+	require.Equal(t, 1, calls)
+}
+
+func Test_MaxBatch(t *testing.T) {
+	c := &ConvoyConfig{RateLimit: 5, RateBurst: 1}
+
+	batched := targetGuards.Guard("test:max-batch", &batchHTTPForwarder{}, c)
+	require.Equal(t, 6, maxBatch(batched))
+
+	single := targetGuards.Guard("test:max-batch-single", &fakeForwarder{}, c)
+	require.Zero(t, maxBatch(single))
+}
@@ -0,0 +1,132 @@
+package ingester
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var ErrInvalidVerifyToken = errors.New("Invalid verify token")
+var ErrHandshakeNotFound = errors.New("Handshake type not found")
+
+// Handshaker answers the subscription challenges some providers send before,
+// or alongside, delivering events. Challenges are answered synchronously and
+// never published.
+type Handshaker interface {
+	// Respond writes the challenge response and reports whether r was a
+	// challenge at all.
+	Respond(w http.ResponseWriter, r *http.Request, payload []byte) (bool, error)
+
+	// Verified reports whether the challenge is signed like an event and
+	// must pass the provider's verifier before Respond is called.
+	Verified() bool
+}
+
+func newHandshaker(c *HandshakeConfig) (Handshaker, error) {
+	switch c.Type {
+	case "slack":
+		return &slackHandshaker{}, nil
+	case "meta":
+		return &metaHandshaker{verifyToken: c.VerifyToken}, nil
+	case "msgraph":
+		return &msGraphHandshaker{}, nil
+	case "zoom":
+		return &zoomHandshaker{secret: c.Secret}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrHandshakeNotFound, c.Type)
+	}
+}
+
+// slackHandshaker answers Slack's url_verification event with its challenge.
+type slackHandshaker struct{}
+
+func (s *slackHandshaker) Respond(w http.ResponseWriter, r *http.Request, payload []byte) (bool, error) {
+	var body struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+	}
+
+	if err := json.Unmarshal(payload, &body); err != nil || body.Type != "url_verification" {
+		return false, nil
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(body.Challenge))
+	return true, nil
+}
+
+func (s *slackHandshaker) Verified() bool { return true }
+
+// metaHandshaker answers the hub.challenge GET Facebook, Instagram and
+// WhatsApp send when a webhook is subscribed.
+type metaHandshaker struct {
+	verifyToken string
+}
+
+func (m *metaHandshaker) Respond(w http.ResponseWriter, r *http.Request, payload []byte) (bool, error) {
+	q := r.URL.Query()
+	if r.Method != http.MethodGet || q.Get("hub.mode") != "subscribe" {
+		return false, nil
+	}
+
+	if len(m.verifyToken) == 0 || !hmac.Equal([]byte(q.Get("hub.verify_token")), []byte(m.verifyToken)) {
+		return true, ErrInvalidVerifyToken
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(q.Get("hub.challenge")))
+	return true, nil
+}
+
+func (m *metaHandshaker) Verified() bool { return false }
+
+// msGraphHandshaker echoes the validationToken Microsoft Graph sends when a
+// subscription is created.
+type msGraphHandshaker struct{}
+
+func (m *msGraphHandshaker) Respond(w http.ResponseWriter, r *http.Request, payload []byte) (bool, error) {
+	token := r.URL.Query().Get("validationToken")
+	if len(token) == 0 {
+		return false, nil
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(token))
+	return true, nil
+}
+
+func (m *msGraphHandshaker) Verified() bool { return false }
+
+// zoomHandshaker answers Zoom's endpoint.url_validation event with the
+// plain token and its HMAC-SHA256 under the webhook secret token.
+type zoomHandshaker struct {
+	secret string
+}
+
+func (z *zoomHandshaker) Respond(w http.ResponseWriter, r *http.Request, payload []byte) (bool, error) {
+	var body struct {
+		Event   string `json:"event"`
+		Payload struct {
+			PlainToken string `json:"plainToken"`
+		} `json:"payload"`
+	}
+
+	if err := json.Unmarshal(payload, &body); err != nil || body.Event != "endpoint.url_validation" {
+		return false, nil
+	}
+
+	mac := hmac.New(sha256.New, []byte(z.secret))
+	mac.Write([]byte(body.Payload.PlainToken))
+
+	w.Header().Set("Content-Type", "application/json")
+	return true, json.NewEncoder(w).Encode(map[string]string{
+		"plainToken":     body.Payload.PlainToken,
+		"encryptedToken": hex.EncodeToString(mac.Sum(nil)),
+	})
+}
+
+func (z *zoomHandshaker) Verified() bool { return true }
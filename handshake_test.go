@@ -0,0 +1,103 @@
+package ingester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WebhookEndpoint_Handshakes(t *testing.T) {
+	// The samples were signed years ago, so the tolerance is widened.
+	t.Setenv(CONFIG_ENV, `[
+		{"name": "slack", "preset": "slack", "verifier_config": {"secret": "Slack Secret", "tolerance": "200000h"}},
+		{"name": "zoom", "preset": "zoom", "verifier_config": {"secret": "Zoom Secret", "tolerance": "200000h"}},
+		{"name": "meta", "preset": "meta", "verifier_config": {"secret": "Meta Secret"}, "handshake": {"verify_token": "meta-token"}},
+		{"name": "msgraph", "handshake": {"type": "msgraph"}}
+	]`)
+	require.NoError(t, LoadConfig(CONFIG_ENV))
+	require.NoError(t, LoadProviderStore())
+
+	tests := map[string]struct {
+		method         string
+		url            string
+		payload        string
+		headers        map[string]string
+		expectedStatus int
+		expectedBody   string
+	}{
+		"slack_url_verification": {
+			method:  http.MethodPost,
+			url:     "/v1/webhooks/slack",
+			payload: `{"token":"Jhj5dZrVaK7ZwHHjRyZWjbDl","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P","type":"url_verification"}`,
+			headers: map[string]string{
+				"X-Slack-Request-Timestamp": "1531420618",
+				"X-Slack-Signature":         "v0=684d6a6d272f80e425b822ab672df706bb309250a320e1e771d9baa89f770a0f",
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P",
+		},
+		"slack_unsigned_url_verification": {
+			method:         http.MethodPost,
+			url:            "/v1/webhooks/slack",
+			payload:        `{"challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P","type":"url_verification"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"zoom_url_validation": {
+			method:  http.MethodPost,
+			url:     "/v1/webhooks/zoom",
+			payload: `{"event":"endpoint.url_validation","payload":{"plainToken":"qgg8vlvZRS6UYooatFL8Aw"},"event_ts":1654503849680}`,
+			headers: map[string]string{
+				"x-zm-request-timestamp": "1654503849",
+				"x-zm-signature":         "v0=0c8d58b9f4182489c2b151a4c72a38eec1133f67d78249e6bd8fa201258f95b7",
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"encryptedToken":"900e2cd46dc944d397217ec5411c782fba7872a834e305b8eb806e73ac6d13b3",` +
+				`"plainToken":"qgg8vlvZRS6UYooatFL8Aw"}` + "\n",
+		},
+		"meta_hub_challenge": {
+			method:         http.MethodGet,
+			url:            "/v1/webhooks/meta?hub.mode=subscribe&hub.verify_token=meta-token&hub.challenge=1158201444",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "1158201444",
+		},
+		"meta_invalid_verify_token": {
+			method:         http.MethodGet,
+			url:            "/v1/webhooks/meta?hub.mode=subscribe&hub.verify_token=wrong&hub.challenge=1158201444",
+			expectedStatus: http.StatusForbidden,
+		},
+		"msgraph_validation_token": {
+			method:         http.MethodPost,
+			url:            "/v1/webhooks/msgraph?validationToken=Validation%3A+Testing+client+application",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "Validation: Testing client application",
+		},
+		"get_without_handshake": {
+			method:         http.MethodGet,
+			url:            "/v1/webhooks/slack",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.payload))
+			for k, v := range tc.headers {
+				req.Header.Add(k, v)
+			}
+			rec := httptest.NewRecorder()
+
+			// Act
+			WebhookEndpoint(rec, req)
+
+			// Assert
+			require.Equal(t, tc.expectedStatus, rec.Code)
+			if len(tc.expectedBody) != 0 {
+				require.Equal(t, tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package ingester
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPath = errors.New("Invalid JSON path")
+
+// parsePath splits a JSON path such as "$.data.items[0].id" into its
+// segments. Only the root, dotted keys and array indexes are supported.
+func parsePath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, ErrInvalidPath
+	}
+
+	path = strings.ReplaceAll(path[1:], "[", ".[")
+
+	var segments []string
+	for _, s := range strings.Split(path, ".") {
+		if len(s) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(s, "[") && !strings.HasSuffix(s, "]") {
+			return nil, ErrInvalidPath
+		}
+
+		segments = append(segments, s)
+	}
+
+	return segments, nil
+}
+
+// lookupPath returns the value at path in a decoded JSON document.
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, false
+	}
+
+	cur := doc
+	for _, s := range segments {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			v, ok := node[s]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []interface{}:
+			i, err := strconv.Atoi(strings.Trim(s, "[]"))
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return cur, true
+}
+
+// lookupPathString decodes payload and returns the value at path as a string.
+func lookupPathString(payload []byte, path string) (string, bool) {
+	doc, err := decodeJSON(payload)
+	if err != nil {
+		return "", false
+	}
+
+	v, ok := lookupPath(doc, path)
+	if !ok {
+		return "", false
+	}
+
+	return stringify(v)
+}
+
+// stringify renders scalar JSON values as strings.
+func stringify(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case json.Number:
+		// Integers are kept as written, however large; other numbers
+		// render as they would from a float64.
+		if !strings.ContainsAny(val.String(), ".eE") {
+			return val.String(), true
+		}
+
+		f, err := val.Float64()
+		if err != nil {
+			return "", false
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(val), true
+	default:
+		return "", false
+	}
+}
+
+// setPath sets the value at path, creating intermediate objects as needed.
+// It returns the updated document, which differs from doc when path is "$".
+func setPath(doc interface{}, path string, value interface{}) (interface{}, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) == 0 {
+		return value, nil
+	}
+
+	parent, err := walkToParent(doc, segments, true)
+	if err != nil {
+		return nil, err
+	}
+
+	last := segments[len(segments)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		i, err := strconv.Atoi(strings.Trim(last, "[]"))
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, ErrInvalidPath
+		}
+		node[i] = value
+	default:
+		return nil, ErrInvalidPath
+	}
+
+	return doc, nil
+}
+
+// deletePath removes the object key at path. Missing keys are ignored.
+func deletePath(doc interface{}, path string) error {
+	segments, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+
+	if len(segments) == 0 {
+		return ErrInvalidPath
+	}
+
+	parent, err := walkToParent(doc, segments, false)
+	if err != nil || parent == nil {
+		return nil
+	}
+
+	if node, ok := parent.(map[string]interface{}); ok {
+		delete(node, segments[len(segments)-1])
+	}
+
+	return nil
+}
+
+// walkToParent returns the node holding the last segment of the path.
+func walkToParent(doc interface{}, segments []string, create bool) (interface{}, error) {
+	cur := doc
+	for _, s := range segments[:len(segments)-1] {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			next, ok := node[s]
+			if !ok || next == nil {
+				if !create {
+					return nil, nil
+				}
+				next = make(map[string]interface{})
+				node[s] = next
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(strings.Trim(s, "[]"))
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, ErrInvalidPath
+			}
+			cur = node[i]
+		default:
+			return nil, ErrInvalidPath
+		}
+	}
+
+	return cur, nil
+}
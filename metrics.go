@@ -0,0 +1,78 @@
+package ingester
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Event counters, keyed by provider. Each count is also logged with a
+// metric field, so every process's counts can be summed with log-based
+// metrics.
+var (
+	eventsPublished = newCounter("events_published")
+	eventsFiltered  = newCounter("events_filtered")
+	eventsDuplicate = newCounter("events_duplicate")
+
+	// eventsDeadLettered and eventsDeferred are counted by PushToConvoy
+	// and the worker, which don't serve metricsHandler.
+	eventsDeadLettered = newCounter("events_dead_lettered")
+
+	// eventsDeferred counts events handed back to Pub/Sub by a target's
+	// rate limit or open circuit, keyed by reason.
+	eventsDeferred = newCounter("events_deferred")
+)
+
+// counter is an expvar map of counts that logs every addition.
+type counter struct {
+	name string
+	m    *expvar.Map
+}
+
+func newCounter(name string) *counter {
+	return &counter{name: name, m: expvar.NewMap(name)}
+}
+
+func (c *counter) Add(key string, delta int64) {
+	c.m.Add(key, delta)
+	log.WithFields(log.Fields{"metric": c.name, "metric_key": key, "count": delta}).Infof("Counted %s", c.name)
+}
+
+// metrics lists the counters metricsHandler serves, in order: those
+// WebhookEndpoint counts.
+var metrics = []string{
+	"events_published",
+	"events_filtered",
+	"events_duplicate",
+}
+
+// metricsHandler serves this instance's event counters as JSON to requests
+// bearing the token in METRICS_TOKEN_ENV, and is off when it isn't set. Unlike expvar's
+// handler, it leaves out the command line and memory stats.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	token := os.Getenv(METRICS_TOKEN_ENV)
+	if len(token) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{")
+	for i, name := range metrics {
+		if i != 0 {
+			fmt.Fprint(w, ",")
+		}
+		fmt.Fprintf(w, "%q:%s", name, expvar.Get(name).String())
+	}
+	fmt.Fprint(w, "}")
+}
@@ -0,0 +1,79 @@
+package ingester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MetricsHandler(t *testing.T) {
+	tests := map[string]struct {
+		token          string
+		auth           string
+		expectedStatus int
+	}{
+		"disabled": {
+			token:          "",
+			auth:           "Bearer ",
+			expectedStatus: http.StatusNotFound,
+		},
+		"missing token": {
+			token:          "metrics-token",
+			auth:           "",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"wrong token": {
+			token:          "metrics-token",
+			auth:           "Bearer other-token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"authorized": {
+			token:          "metrics-token",
+			auth:           "Bearer metrics-token",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			t.Setenv(METRICS_TOKEN_ENV, tc.token)
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.Header.Set("Authorization", tc.auth)
+			rec := httptest.NewRecorder()
+
+			// Act
+			WebhookEndpoint(rec, req)
+
+			// Assert
+			require.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedStatus == http.StatusOK {
+				require.Contains(t, rec.Body.String(), `"events_published":`)
+				require.NotContains(t, rec.Body.String(), "cmdline")
+				require.NotContains(t, rec.Body.String(), "events_dead_lettered")
+			}
+		})
+	}
+}
+
+func Test_Counter_Add(t *testing.T) {
+	// Arrange
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	c := newCounter("events_counted")
+
+	// Act
+	c.Add("paystack", 1)
+
+	// Assert
+	require.Equal(t, "1", c.m.Get("paystack").String())
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Equal(t, "events_counted", entry.Data["metric"])
+	require.Equal(t, "paystack", entry.Data["metric_key"])
+	require.Equal(t, int64(1), entry.Data["count"])
+}
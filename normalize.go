@@ -0,0 +1,157 @@
+package ingester
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidXML = errors.New("Invalid XML payload")
+
+// normalizePayload turns payloads that aren't JSON into JSON, so they can be
+// carried in a Convoy event:
+//
+//	form data:  an object of parameters, repeated parameters as arrays
+//	XML:        an object keyed by element name, attributes prefixed with @
+//	and text in #text when an element also has attributes or children
+//	text:       {"content_type": ..., "encoding": "utf-8", "data": text}
+//	binary:     {"content_type": ..., "encoding": "base64", "data": base64}
+//
+// JSON payloads are returned as is.
+func normalizePayload(contentType string, payload []byte) ([]byte, error) {
+	if json.Valid(payload) {
+		return payload, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return formToJSON(payload)
+	case mediaType == "application/xml", mediaType == "text/xml", strings.HasSuffix(mediaType, "+xml"):
+		return xmlToJSON(payload)
+	case len(payload) == 0:
+		return []byte(`{}`), nil
+	case strings.HasPrefix(mediaType, "text/") && utf8.Valid(payload):
+		return json.Marshal(map[string]string{
+			"content_type": contentType,
+			"encoding":     "utf-8",
+			"data":         string(payload),
+		})
+	default:
+		return json.Marshal(map[string]string{
+			"content_type": contentType,
+			"encoding":     "base64",
+			"data":         base64.StdEncoding.EncodeToString(payload),
+		})
+	}
+}
+
+func formToJSON(payload []byte) ([]byte, error) {
+	form, err := url.ParseQuery(string(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	obj := make(map[string]interface{}, len(form))
+	for k, v := range form {
+		if len(v) == 1 {
+			obj[k] = v[0]
+			continue
+		}
+		obj[k] = v
+	}
+
+	return json.Marshal(obj)
+}
+
+// xmlNode is an element while an XML document is converted.
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     strings.Builder
+}
+
+func xmlToJSON(payload []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(payload))
+
+	var root *xmlNode
+	var stack []*xmlNode
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrInvalidXML
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name.Local, attrs: t.Attr}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, ErrInvalidXML
+				}
+				root = n
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) != 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, ErrInvalidXML
+	}
+
+	return json.Marshal(map[string]interface{}{root.name: root.value()})
+}
+
+func (n *xmlNode) value() interface{} {
+	text := strings.TrimSpace(n.text.String())
+	if len(n.attrs) == 0 && len(n.children) == 0 {
+		return text
+	}
+
+	obj := make(map[string]interface{})
+	for _, a := range n.attrs {
+		obj["@"+a.Name.Local] = a.Value
+	}
+
+	for _, c := range n.children {
+		v := c.value()
+		switch existing := obj[c.name].(type) {
+		case nil:
+			obj[c.name] = v
+		case []interface{}:
+			obj[c.name] = append(existing, v)
+		default:
+			obj[c.name] = []interface{}{existing, v}
+		}
+	}
+
+	if len(text) != 0 {
+		obj["#text"] = text
+	}
+
+	return obj
+}
@@ -0,0 +1,67 @@
+package ingester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NormalizePayload(t *testing.T) {
+	tests := map[string]struct {
+		contentType     string
+		payload         string
+		expectedPayload string
+	}{
+		"json": {
+			contentType:     "application/json",
+			payload:         `{"event": "charge.success"}`,
+			expectedPayload: `{"event": "charge.success"}`,
+		},
+		"form": {
+			contentType:     "application/x-www-form-urlencoded; charset=utf-8",
+			payload:         "MessageSid=SM123&Body=Hello+there&MediaUrl=a&MediaUrl=b",
+			expectedPayload: `{"Body":"Hello there","MediaUrl":["a","b"],"MessageSid":"SM123"}`,
+		},
+		"xml": {
+			contentType: "application/xml",
+			payload: `<?xml version="1.0"?>
+				<notification type="payment">
+					<id>42</id>
+					<item sku="a">First</item>
+					<item>Second</item>
+				</notification>`,
+			expectedPayload: `{"notification":{"@type":"payment","id":"42","item":[{"#text":"First","@sku":"a"},"Second"]}}`,
+		},
+		"text": {
+			contentType:     "text/plain",
+			payload:         "ping",
+			expectedPayload: `{"content_type":"text/plain","data":"ping","encoding":"utf-8"}`,
+		},
+		"binary": {
+			contentType:     "application/octet-stream",
+			payload:         "\x00\x01\x02",
+			expectedPayload: `{"content_type":"application/octet-stream","data":"AAEC","encoding":"base64"}`,
+		},
+		"empty": {
+			contentType:     "",
+			payload:         "",
+			expectedPayload: `{}`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			out, err := normalizePayload(tc.contentType, []byte(tc.payload))
+
+			// Assert
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPayload, string(out))
+		})
+	}
+}
+
+func Test_NormalizePayload_InvalidXML(t *testing.T) {
+	_, err := normalizePayload("text/xml", []byte(`<notification><id>42</notification>`))
+	require.ErrorIs(t, err, ErrInvalidXML)
+}
@@ -0,0 +1,33 @@
+package ingester
+
+import "strings"
+
+// expandPlaceholders replaces each {field} in tmpl with the value lookup
+// returns for it. Fields lookup doesn't know are left as they are.
+func expandPlaceholders(tmpl string, lookup func(field string) (string, bool)) string {
+	var b strings.Builder
+	for len(tmpl) > 0 {
+		start := strings.Index(tmpl, "{")
+		if start < 0 {
+			b.WriteString(tmpl)
+			break
+		}
+
+		end := strings.Index(tmpl[start:], "}")
+		if end < 0 {
+			b.WriteString(tmpl)
+			break
+		}
+		end += start
+
+		b.WriteString(tmpl[:start])
+		if v, ok := lookup(tmpl[start+1 : end]); ok {
+			b.WriteString(v)
+		} else {
+			b.WriteString(tmpl[start : end+1])
+		}
+		tmpl = tmpl[end+1:]
+	}
+
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package ingester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExpandPlaceholders(t *testing.T) {
+	lookup := func(field string) (string, bool) {
+		if field == "header:X" {
+			return "value", true
+		}
+		return "", false
+	}
+
+	tests := map[string]struct {
+		tmpl     string
+		expected string
+	}{
+		"known field": {
+			tmpl:     "v0:{header:X}:end",
+			expected: "v0:value:end",
+		},
+		"unknown field": {
+			tmpl:     "{other}{header:X}",
+			expected: "{other}value",
+		},
+		"closing brace before the field": {
+			tmpl:     "a}{header:X}",
+			expected: "a}value",
+		},
+		"unclosed field": {
+			tmpl:     "a{header:X",
+			expected: "a{header:X",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			got := expandPlaceholders(tc.tmpl, lookup)
+
+			// Assert
+			require.Equal(t, tc.expected, got)
+		})
+	}
+}
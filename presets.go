@@ -0,0 +1,148 @@
+package ingester
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var ErrPresetNotFound = errors.New("Preset not found")
+
+// presets are partial provider configurations for well-known providers.
+// Values set in the provider's own configuration take precedence, so a
+// preset usually only needs the secret filled in.
+var presets = map[string]string{
+	"paystack": `{
+		"verifier_config": {
+			"type": "hmac",
+			"header": "X-Paystack-Signature",
+			"hash": "SHA512"
+		},
+		"event_type": {"path": "$.event"}
+	}`,
+	"github": `{
+		"verifier_config": {
+			"type": "hmac",
+			"header": "X-Hub-Signature-256",
+			"hash": "SHA256",
+			"prefix": "sha256="
+		},
+		"event_type": {"header": "X-GitHub-Event"},
+		"idempotency": {"header": "X-GitHub-Delivery"}
+	}`,
+	"shopify": `{
+		"verifier_config": {
+			"type": "hmac",
+			"header": "X-Shopify-Hmac-Sha256",
+			"hash": "SHA256",
+			"encoding": "base64"
+		},
+		"event_type": {"header": "X-Shopify-Topic"},
+		"idempotency": {"header": "X-Shopify-Webhook-Id"}
+	}`,
+	"slack": `{
+		"verifier_config": {
+			"type": "hmac",
+			"header": "X-Slack-Signature",
+			"hash": "SHA256",
+			"prefix": "v0=",
+			"signed_payload": "v0:{header:X-Slack-Request-Timestamp}:{body}",
+			"timestamp": "{header:X-Slack-Request-Timestamp}"
+		},
+		"event_type": {"path": "$.event.type"},
+		"idempotency": {"path": "$.event_id"},
+		"handshake": {"type": "slack"}
+	}`,
+	"stripe": `{
+		"verifier_config": {
+			"type": "hmac",
+			"header": "Stripe-Signature",
+			"hash": "SHA256",
+			"signature_key": "v1",
+			"signed_payload": "{param:t}.{body}",
+			"timestamp": "{param:t}"
+		},
+		"event_type": {"path": "$.type"},
+		"idempotency": {"path": "$.id"}
+	}`,
+	"zoom": `{
+		"verifier_config": {
+			"type": "hmac",
+			"header": "x-zm-signature",
+			"hash": "SHA256",
+			"prefix": "v0=",
+			"signed_payload": "v0:{header:x-zm-request-timestamp}:{body}",
+			"timestamp": "{header:x-zm-request-timestamp}"
+		},
+		"event_type": {"path": "$.event"},
+		"handshake": {"type": "zoom"}
+	}`,
+	"meta": `{
+		"verifier_config": {
+			"type": "hmac",
+			"header": "X-Hub-Signature-256",
+			"hash": "SHA256",
+			"prefix": "sha256="
+		},
+		"event_type": {"path": "$.object"},
+		"handshake": {"type": "meta"}
+	}`,
+	"twilio": `{
+		"verifier_config": {
+			"type": "hmac",
+			"header": "X-Twilio-Signature",
+			"hash": "SHA1",
+			"encoding": "base64",
+			"signed_payload": "{url}{form}"
+		},
+		"idempotency": {"header": "I-Twilio-Idempotency-Token"}
+	}`,
+	"flutterwave": `{
+		"verifier_config": {
+			"type": "api_key",
+			"header": "verif-hash"
+		},
+		"event_type": {"path": "$.event"}
+	}`,
+	"mono": `{
+		"verifier_config": {
+			"type": "api_key",
+			"header": "mono-webhook-secret"
+		},
+		"event_type": {"path": "$.event"}
+	}`,
+}
+
+// applyPreset merges the provider configuration in data over the named preset.
+func applyPreset(name string, data []byte) ([]byte, error) {
+	preset, ok := presets[name]
+	if !ok {
+		return nil, ErrPresetNotFound
+	}
+
+	var base, override map[string]interface{}
+	if err := unmarshalJSON([]byte(preset), &base); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalJSON(data, &override); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergeObjects(base, override))
+}
+
+// mergeObjects deep merges override into base.
+func mergeObjects(base, override map[string]interface{}) map[string]interface{} {
+	for k, v := range override {
+		bv, bOk := base[k].(map[string]interface{})
+		ov, oOk := v.(map[string]interface{})
+		if bOk && oOk {
+			base[k] = mergeObjects(bv, ov)
+			continue
+		}
+
+		base[k] = v
+	}
+
+	return base
+}
@@ -0,0 +1,284 @@
+package ingester
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Presets_VerifyRequest(t *testing.T) {
+	tests := map[string]struct {
+		config        string
+		url           string
+		payload       string
+		headers       map[string]string
+		now           int64
+		expectedError error
+	}{
+		// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+		"github": {
+			config: `{
+				"name": "github",
+				"preset": "github",
+				"verifier_config": {"secret": "It's a Secret to Everybody"}
+			}`,
+			payload: `Hello, World!`,
+			headers: map[string]string{
+				"X-Hub-Signature-256": "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+			},
+		},
+		// https://api.slack.com/authentication/verifying-requests-from-slack
+		"slack": {
+			config: `{
+				"name": "slack",
+				"preset": "slack",
+				"verifier_config": {"secret": "8f742231b10e8888abcd99yyyzzz85a5"}
+			}`,
+			payload: "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow" +
+				"&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner" +
+				"&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands" +
+				"%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN" +
+				"&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c",
+			headers: map[string]string{
+				"X-Slack-Request-Timestamp": "1531420618",
+				"X-Slack-Signature":         "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503",
+			},
+			now: 1531420618,
+		},
+		"slack_replayed": {
+			config: `{
+				"name": "slack",
+				"preset": "slack",
+				"verifier_config": {"secret": "8f742231b10e8888abcd99yyyzzz85a5"}
+			}`,
+			payload: "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow" +
+				"&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner" +
+				"&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands" +
+				"%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN" +
+				"&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c",
+			headers: map[string]string{
+				"X-Slack-Request-Timestamp": "1531420618",
+				"X-Slack-Signature":         "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503",
+			},
+			now:           1531420618 + 301,
+			expectedError: ErrTimestampOutOfTolerance,
+		},
+		// Zoom signs "v0:<timestamp>:<body>" like Slack and publishes no
+		// sample with a secret, so Slack's documented sample is reused with
+		// Zoom's header names.
+		"zoom": {
+			config: `{
+				"name": "zoom",
+				"preset": "zoom",
+				"verifier_config": {"secret": "8f742231b10e8888abcd99yyyzzz85a5"}
+			}`,
+			payload: "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow" +
+				"&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner" +
+				"&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands" +
+				"%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN" +
+				"&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c",
+			headers: map[string]string{
+				"x-zm-request-timestamp": "1531420618",
+				"x-zm-signature":         "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503",
+			},
+			now: 1531420618,
+		},
+		"zoom_replayed": {
+			config: `{
+				"name": "zoom",
+				"preset": "zoom",
+				"verifier_config": {"secret": "8f742231b10e8888abcd99yyyzzz85a5"}
+			}`,
+			payload: "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow" +
+				"&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner" +
+				"&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands" +
+				"%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN" +
+				"&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c",
+			headers: map[string]string{
+				"x-zm-request-timestamp": "1531420618",
+				"x-zm-signature":         "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503",
+			},
+			now:           1531420618 + 3600,
+			expectedError: ErrTimestampOutOfTolerance,
+		},
+		// Meta signs the body like GitHub's X-Hub-Signature-256, so
+		// GitHub's documented sample is reused.
+		// https://developers.facebook.com/docs/graph-api/webhooks/getting-started#validate-payloads
+		"meta": {
+			config: `{
+				"name": "meta",
+				"preset": "meta",
+				"verifier_config": {"secret": "It's a Secret to Everybody"}
+			}`,
+			payload: `Hello, World!`,
+			headers: map[string]string{
+				"X-Hub-Signature-256": "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+			},
+		},
+		// Stripe documents the scheme but no payload and secret pair, and
+		// stripe-go's tests compute their signatures too. This one is
+		//   printf '%s' "1492774577.<payload>" | openssl dgst -sha256 -hmac whsec_test_secret
+		// next to a v1 from an older secret and a v0, as Stripe sends them
+		// while a secret is rolled.
+		// https://docs.stripe.com/webhooks#verify-manually
+		"stripe": {
+			config: `{
+				"name": "stripe",
+				"preset": "stripe",
+				"verifier_config": {"secret": "whsec_test_secret"}
+			}`,
+			payload: `{"id":"evt_1NG8Du2eZvKYlo2CUI79vXWy","object":"event","type":"payment_intent.succeeded"}`,
+			headers: map[string]string{
+				"Stripe-Signature": "t=1492774577," +
+					"v1=c35406c7bbcd1b3b5f8d107f2ed43cbfb947cdf562c4132dcdb2c5da82e5709e," +
+					"v1=174d5523cfbe4e1b5329c200cb77563aab3083d19aed8962b99a62deedaeffe7," +
+					"v0=6ffbb59b2300aae63f272406069a9788598b792a944a07aba816edb039989a39",
+			},
+			now: 1492774577 + 60,
+		},
+		"stripe_wrong_secret": {
+			config: `{
+				"name": "stripe",
+				"preset": "stripe",
+				"verifier_config": {"secret": "whsec_other_secret"}
+			}`,
+			payload: `{"id":"evt_1NG8Du2eZvKYlo2CUI79vXWy","object":"event","type":"payment_intent.succeeded"}`,
+			headers: map[string]string{
+				"Stripe-Signature": "t=1492774577,v1=174d5523cfbe4e1b5329c200cb77563aab3083d19aed8962b99a62deedaeffe7",
+			},
+			now:           1492774577,
+			expectedError: ErrHashDoesNotMatch,
+		},
+		"stripe_replayed": {
+			config: `{
+				"name": "stripe",
+				"preset": "stripe",
+				"verifier_config": {"secret": "whsec_test_secret"}
+			}`,
+			payload: `{"id":"evt_1NG8Du2eZvKYlo2CUI79vXWy","object":"event","type":"payment_intent.succeeded"}`,
+			headers: map[string]string{
+				"Stripe-Signature": "t=1492774577,v1=174d5523cfbe4e1b5329c200cb77563aab3083d19aed8962b99a62deedaeffe7",
+			},
+			now:           1492774577 + 600,
+			expectedError: ErrTimestampOutOfTolerance,
+		},
+		// Shopify publishes no sample, so this is
+		//   printf '%s' '<payload>' | openssl dgst -sha256 -hmac 'Shopify Secret' -binary | base64
+		"shopify": {
+			config: `{
+				"name": "shopify",
+				"preset": "shopify",
+				"verifier_config": {"secret": "Shopify Secret"}
+			}`,
+			payload: `{"id":820982911946154508,"email":"jon@doe.ca"}`,
+			headers: map[string]string{
+				"X-Shopify-Hmac-Sha256": "De1JUeQL1DvpzeVRD2ktaBedypbjTCA5+Y+YvwcMq3g=",
+			},
+		},
+		// Paystack publishes no sample, so this is
+		//   printf '%s' '<payload>' | openssl dgst -sha512 -hmac 'Paystack Secret'
+		"paystack": {
+			config: `{
+				"name": "paystack",
+				"preset": "paystack",
+				"verifier_config": {"secret": "Paystack Secret"}
+			}`,
+			payload: `{"event":"charge.success","data":{"id":302961}}`,
+			headers: map[string]string{
+				"X-Paystack-Signature": "f95c8036e3f49c91499165d566b96fd380e066130f1d0b75e5766a87fed395dd" +
+					"e830e4afa713a38efdcfad39105989663c32a30edb6f941b2b75bb2cd5948a66",
+			},
+		},
+		// https://www.twilio.com/docs/usage/security#validating-requests
+		"twilio": {
+			config: `{
+				"name": "twilio",
+				"preset": "twilio",
+				"verifier_config": {"secret": "12345", "forwarded_headers": true}
+			}`,
+			url:     "http://10.0.0.1/myapp.php?foo=1&bar=2",
+			payload: "CallSid=CA1234567890ABCDE&Caller=%2B12349013030&Digits=1234&From=%2B12349013030&To=%2B18005551212",
+			headers: map[string]string{
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Host":   "mycompany.com",
+				"X-Twilio-Signature": "0/KCTR6DLpKmkAf8muzZqo1nDgQ=",
+			},
+		},
+		"flutterwave": {
+			config: `{
+				"name": "flutterwave",
+				"preset": "flutterwave",
+				"verifier_config": {"api_key": "Flutterwave Hash"}
+			}`,
+			payload: `{"event":"charge.completed"}`,
+			headers: map[string]string{
+				"verif-hash": "Flutterwave Hash",
+			},
+		},
+		"mono": {
+			config: `{
+				"name": "mono",
+				"preset": "mono",
+				"verifier_config": {"api_key": "sec_secretphrase"}
+			}`,
+			payload: `{"event":"mono.events.account_updated"}`,
+			headers: map[string]string{
+				"mono-webhook-secret": "sec_secretphrase",
+			},
+		},
+		"overridden_preset_value": {
+			config: `{
+				"name": "github",
+				"preset": "github",
+				"verifier_config": {"secret": "It's a Secret to Everybody", "header": "X-Signature"}
+			}`,
+			payload: `Hello, World!`,
+			headers: map[string]string{
+				"X-Hub-Signature-256": "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+			},
+			expectedError: ErrSignatureCannotBeEmpty,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			t.Setenv(CONFIG_ENV, "["+tc.config+"]")
+			require.NoError(t, LoadConfig(CONFIG_ENV))
+			require.NoError(t, LoadProviderStore())
+
+			p := providerStore[(*configStore)[0].Name]
+			if v, ok := p.verifier.(*HmacVerifier); ok && tc.now != 0 {
+				v.now = func() time.Time { return time.Unix(tc.now, 0) }
+			}
+
+			url := tc.url
+			if len(url) == 0 {
+				url = "URL"
+			}
+
+			req, err := http.NewRequest("POST", url, strings.NewReader(tc.payload))
+			require.NoError(t, err)
+
+			for k, v := range tc.headers {
+				req.Header.Add(k, v)
+			}
+
+			// Act
+			err = p.VerifyRequest(req, []byte(tc.payload))
+
+			// Assert
+			require.ErrorIs(t, err, tc.expectedError)
+		})
+	}
+}
+
+func Test_LoadConfig_UnknownPreset(t *testing.T) {
+	t.Setenv(CONFIG_ENV, `[{"name": "acme", "preset": "acme"}]`)
+
+	err := LoadConfig(CONFIG_ENV)
+	require.ErrorIs(t, err, ErrPresetNotFound)
+}
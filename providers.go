@@ -1,37 +1,304 @@
 package ingester
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 )
 
+var ErrProviderNotFound = errors.New("Provider not found")
+var ErrVerifierNotConfigured = errors.New("Provider has no verifier configured")
+
 // Store
 type ProviderStore map[string]*Provider
 
 type Provider struct {
-	Name     string
-	AppID    string
-	verifier Verifier
+	Name        string
+	AppID       string
+	Format      string
+	orderingKey string
+	verifier    Verifier
+	eventType   *eventTypeExtractor
+	router      *appRouter
+	filters     *FilterConfig
+	idempotency *idempotencyKeyExtractor
+	handshake   Handshaker
+	transformer *transformer
+	script      *script
+	envelope    *envelope
+	convoy      *ConvoyTargetConfig
+	forwarder   *httpForwarder
+
+	// convoyTimeout overrides the timeout of requests to Convoy when set.
+	convoyTimeout time.Duration
+
+	// scriptVerifies is set when the script verifies webhooks in place of
+	// a verifier.
+	scriptVerifies bool
 }
 
+// VerifyRequest checks the request with the provider's verifier. Providers
+// without one are only accepted when their script is set to verify.
 func (p *Provider) VerifyRequest(r *http.Request, payload []byte) error {
+	if p.verifier == nil {
+		if p.scriptVerifies {
+			return nil
+		}
+		return ErrVerifierNotConfigured
+	}
+
 	return p.verifier.VerifyRequest(r, payload)
 }
 
+// Handshake returns the provider's challenge handler, or nil.
+func (p *Provider) Handshake() Handshaker {
+	return p.handshake
+}
+
+// Unwrap strips the verifier's envelope from payload, e.g. SNS's, when the
+// verifier has one.
+func (p *Provider) Unwrap(payload []byte) ([]byte, error) {
+	u, ok := p.verifier.(interface {
+		Unwrap(payload []byte) ([]byte, error)
+	})
+	if !ok {
+		return payload, nil
+	}
+
+	return u.Unwrap(payload)
+}
+
+// RunScript runs the provider's script. Providers without a script accept
+// every webhook unchanged.
+func (p *Provider) RunScript(r *http.Request, payload []byte) (*scriptResult, error) {
+	if p.script == nil {
+		return &scriptResult{}, nil
+	}
+
+	return p.script.Run(r, payload)
+}
+
+func (p *Provider) EventType(r *http.Request, payload []byte) string {
+	return p.eventType.Extract(r, payload)
+}
+
+func (p *Provider) Transform(payload []byte) ([]byte, error) {
+	return p.transformer.Transform(payload)
+}
+
+// IdempotencyKey returns the webhook's idempotency key, namespaced by
+// provider, or an empty string when it has none.
+func (p *Provider) IdempotencyKey(r *http.Request, payload []byte) string {
+	key := p.idempotency.Extract(r, payload)
+	if len(key) == 0 {
+		return ""
+	}
+
+	return p.Name + ":" + key
+}
+
+// IdempotencyTTL is how long the provider's idempotency keys are remembered.
+func (p *Provider) IdempotencyTTL() time.Duration {
+	return p.idempotency.ttl
+}
+
+// Accepts reports whether the webhook passes the provider's filters.
+func (p *Provider) Accepts(w *webhook) bool {
+	return p.filters.Accepts(w)
+}
+
+// Wrap adds the request's metadata to payload when the provider has an
+// envelope configured.
+func (p *Provider) Wrap(r *http.Request, payload []byte) ([]byte, error) {
+	if p.envelope == nil {
+		return payload, nil
+	}
+
+	return p.envelope.Wrap(r, payload)
+}
+
+// OrderingKey renders the provider's ordering key for an event to appID.
+// Events without one are delivered in any order.
+func (p *Provider) OrderingKey(w *webhook, appID string) string {
+	if len(p.orderingKey) == 0 {
+		return ""
+	}
+
+	return expandPlaceholders(p.orderingKey, func(field string) (string, bool) {
+		switch {
+		case field == "provider":
+			return p.Name, true
+		case field == "event":
+			return w.eventType, true
+		case field == "app_id":
+			return appID, true
+		case strings.HasPrefix(field, "header:"):
+			return w.header.Get(strings.TrimPrefix(field, "header:")), true
+		case strings.HasPrefix(field, "path:"):
+			v, _ := lookupPath(w.body, strings.TrimPrefix(field, "path:"))
+			s, _ := stringify(v)
+			return s, true
+		default:
+			return "", false
+		}
+	})
+}
+
+// ConvoyTarget returns the Convoy tenant the provider's events are pushed
+// to, or nil for the default one.
+func (p *Provider) ConvoyTarget() *convoyTarget {
+	if p.convoy == nil {
+		return nil
+	}
+
+	return &convoyTarget{
+		URL:       p.convoy.URL,
+		GroupID:   p.convoy.GroupID,
+		ProjectID: p.convoy.ProjectID,
+		APIKeyEnv: p.convoy.APIKeyEnv,
+
+		API:          p.convoy.API,
+		SourceMaskID: p.convoy.SourceMaskID,
+	}
+}
+
+// AppIDs returns the Convoy apps the webhook should be delivered to.
+func (p *Provider) AppIDs(w *webhook) []string {
+	return p.router.AppIDs(w)
+}
+
+// LookupProvider returns the provider registered under name.
+func LookupProvider(name string) (*Provider, error) {
+	p, ok := providerStore[name]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+
+	return p, nil
+}
+
 func LoadProviderStore() error {
 
 	// Create registry from configuration
 	for _, c := range *configStore {
 		p := &Provider{
-			Name:  c.Name,
-			AppID: c.AppID,
+			Name:   c.Name,
+			AppID:  c.AppID,
+			Format: c.Format,
+
+			orderingKey: c.OrderingKey,
+			eventType: &eventTypeExtractor{
+				provider: c.Name,
+				config:   c.EventType,
+			},
+			router: &appRouter{
+				appID:  c.AppID,
+				routes: c.Routes,
+			},
+			filters: c.Filters,
+			convoy:  c.Convoy,
+		}
+
+		if c.Convoy != nil {
+			if len(c.Convoy.APIKeyEnv) != 0 && !strings.HasPrefix(c.Convoy.APIKeyEnv, "CONVOY_") {
+				return fmt.Errorf("%s: %w", c.Name, ErrInvalidAPIKeyEnv)
+			}
+
+			if len(c.Convoy.Timeout) != 0 {
+				d, err := time.ParseDuration(c.Convoy.Timeout)
+				if err != nil {
+					return fmt.Errorf("%s: convoy timeout: %w", c.Name, err)
+				}
+				p.convoyTimeout = d
+			}
+		}
+
+		if c.HTTP != nil {
+			if c.Convoy != nil {
+				return fmt.Errorf("%s: %w", c.Name, ErrMultipleTargets)
+			}
+
+			f, err := newHTTPForwarder(c.HTTP)
+			if err != nil {
+				return fmt.Errorf("%s: %w", c.Name, err)
+			}
+			p.forwarder = f
+		}
+
+		if p.forwarder == nil {
+			if _, err := newConvoyForwarder(nil, p.ConvoyTarget()); err != nil {
+				return fmt.Errorf("%s: %w", c.Name, err)
+			}
+		}
+
+		switch c.Format {
+		case "", "convoy", "cloudevents":
+		default:
+			return fmt.Errorf("%s: unknown format %q", c.Name, c.Format)
+		}
+
+		t, err := newTransformer(c.Transform)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+		p.transformer = t
+
+		ie, err := newIdempotencyKeyExtractor(c.Idempotency)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+		p.idempotency = ie
+
+		if c.Handshake != nil {
+			hc := *c.Handshake
+			if len(hc.Secret) == 0 && c.VerifierConfig.HmacConfig != nil {
+				hc.Secret = c.VerifierConfig.HmacConfig.Secret
+			}
+
+			h, err := newHandshaker(&hc)
+			if err != nil {
+				return fmt.Errorf("%s: %w", c.Name, err)
+			}
+			p.handshake = h
+		}
+
+		if c.Script != nil {
+			s, err := newScript(c.Name, c.Script)
+			if err != nil {
+				return fmt.Errorf("%s: %w", c.Name, err)
+			}
+			p.script = s
+			p.scriptVerifies = c.Script.Verify
 		}
 
 		if c.VerifierConfig.HmacConfig != nil {
-			p.verifier = &HmacVerifier{c.VerifierConfig.HmacConfig}
+			if t := c.VerifierConfig.HmacConfig.Tolerance; len(t) != 0 {
+				if _, err := time.ParseDuration(t); err != nil {
+					return fmt.Errorf("%s: tolerance: %w", c.Name, err)
+				}
+			}
+			p.verifier = &HmacVerifier{config: c.VerifierConfig.HmacConfig}
 		} else if c.VerifierConfig.BasicAuthConfig != nil {
 			p.verifier = &BasicAuthVerifier{c.VerifierConfig.BasicAuthConfig}
 		} else if c.VerifierConfig.APIKeyConfig != nil {
 			p.verifier = &APIKeyVerifier{c.VerifierConfig.APIKeyConfig}
+		} else if c.VerifierConfig.SNSConfig != nil {
+			sV := &SNSVerifier{c.VerifierConfig.SNSConfig, defaultCertFetcher}
+			p.verifier = sV
+
+			if c.VerifierConfig.SNSConfig.AutoConfirm && p.handshake == nil {
+				p.handshake = &snsHandshaker{verifier: sV, client: &http.Client{Timeout: 10 * time.Second}}
+			}
+		}
+
+		if c.Envelope != nil {
+			var secretHeader string
+			if c.VerifierConfig.APIKeyConfig != nil {
+				secretHeader = c.VerifierConfig.APIKeyConfig.Header
+			}
+			p.envelope = newEnvelope(c.Name, c.Envelope, secretHeader)
 		}
 
 		providerStore[c.Name] = p
@@ -0,0 +1,113 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Delivery attempts made per invocation before Pub/Sub redelivers the
+// message. The wait doubles after each attempt.
+var (
+	maxPushAttempts = 3
+	pushBackoff     = 500 * time.Millisecond
+
+	// sleep is replaced in tests.
+	sleep = sleepContext
+)
+
+// sleepContext waits for d, or returns ctx's error once ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// permanentError is a failure that retrying can't fix, e.g. a malformed
+// message or a request Convoy rejected.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent marks err as not worth retrying.
+func permanent(err error) error {
+	return &permanentError{err}
+}
+
+// statusError is a non-2xx response from the destination.
+type statusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+// isPermanent reports whether err won't go away on retry. 4xx responses
+// are permanent, except timeouts and rate limits; 5xx responses and
+// network errors are transient.
+func isPermanent(err error) bool {
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return true
+	}
+
+	var se *statusError
+	if errors.As(err, &se) {
+		switch {
+		case se.StatusCode == http.StatusRequestTimeout,
+			se.StatusCode == http.StatusTooManyRequests,
+			se.StatusCode >= 500:
+			return false
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// withRetry calls fn until it succeeds, fails permanently, is paused by a
+// guard, runs out of attempts or ctx is done. It returns the failed
+// attempts and the last error.
+func withRetry(ctx context.Context, fn func(ctx context.Context) error) ([]Attempt, error) {
+	wait := pushBackoff
+
+	var attempts []Attempt
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return attempts, nil
+		}
+
+		attempts = append(attempts, Attempt{At: time.Now().UTC(), Error: err.Error()})
+		if isPermanent(err) || isPaused(err) || attempt >= maxPushAttempts || ctx.Err() != nil {
+			return attempts, err
+		}
+
+		log.WithError(err).Warnf("Delivery attempt %d failed, retrying in %s", attempt, wait)
+		if sleep(ctx, wait) != nil {
+			return attempts, err
+		}
+		wait *= 2
+	}
+}
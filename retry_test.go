@@ -0,0 +1,153 @@
+package ingester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingDeadLetterSink struct {
+	letters []*DeadLetter
+}
+
+func (s *recordingDeadLetterSink) DeadLetter(d *DeadLetter) error {
+	s.letters = append(s.letters, d)
+	return nil
+}
+
+func Test_IsPermanent(t *testing.T) {
+	tests := map[string]struct {
+		err       error
+		permanent bool
+	}{
+		"bad request":   {err: &statusError{StatusCode: http.StatusBadRequest}, permanent: true},
+		"unauthorized":  {err: &statusError{StatusCode: http.StatusUnauthorized}, permanent: true},
+		"rate limited":  {err: &statusError{StatusCode: http.StatusTooManyRequests}, permanent: false},
+		"timeout":       {err: &statusError{StatusCode: http.StatusRequestTimeout}, permanent: false},
+		"server error":  {err: &statusError{StatusCode: http.StatusBadGateway}, permanent: false},
+		"network error": {err: errors.New("connection refused"), permanent: false},
+		"marked":        {err: permanent(errors.New("bad payload")), permanent: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.permanent, isPermanent(tc.err))
+		})
+	}
+}
+
+func Test_PushToConvoy_Failures(t *testing.T) {
+	message := pubSubMessage{
+		Data: []byte(`{"id":302961}`),
+		Attributes: map[string]string{
+			"provider":   "paystack",
+			"app_id":     "app-id",
+			"event_type": "charge.success",
+		},
+	}
+
+	tests := map[string]struct {
+		message        pubSubMessage
+		statuses       []int
+		expectedErr    bool
+		expectedCalls  int
+		expectedLetter bool
+		expectedTries  int
+	}{
+		"delivered": {
+			message:       message,
+			statuses:      []int{http.StatusCreated},
+			expectedCalls: 1,
+		},
+		"recovers from server error": {
+			message:       message,
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusCreated},
+			expectedCalls: 2,
+		},
+		"retries rate limits then returns error": {
+			message:       message,
+			statuses:      []int{http.StatusTooManyRequests},
+			expectedErr:   true,
+			expectedCalls: 3,
+		},
+		"dead-letters rejected event": {
+			message:        message,
+			statuses:       []int{http.StatusBadRequest},
+			expectedCalls:  1,
+			expectedLetter: true,
+			expectedTries:  1,
+		},
+		"dead-letters malformed message": {
+			message:        pubSubMessage{Data: []byte(`not json`)},
+			expectedCalls:  0,
+			expectedLetter: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tc.statuses[len(tc.statuses)-1]
+				if calls < len(tc.statuses) {
+					status = tc.statuses[calls]
+				}
+				calls++
+
+				w.WriteHeader(status)
+				w.Write([]byte(`{"status":false,"message":"failed"}`))
+			}))
+			defer srv.Close()
+			prevAPI := convoyAPI
+			convoyAPI = newConvoyClient(&ConvoyConfig{URL: srv.URL})
+			defer func() { convoyAPI = prevAPI }()
+
+			sink := &recordingDeadLetterSink{}
+			prevSink, prevSleep := deadLetterSink, sleep
+			deadLetterSink, sleep = sink, func(context.Context, time.Duration) error { return nil }
+			defer func() { deadLetterSink, sleep = prevSink, prevSleep }()
+
+			// Act
+			err := PushToConvoy(context.Background(), tc.message)
+
+			// Assert
+			if tc.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, tc.expectedCalls, calls)
+			require.Equal(t, tc.expectedLetter, len(sink.letters) == 1)
+			if tc.expectedLetter {
+				require.Equal(t, tc.message.Data, sink.letters[0].Data)
+				require.Len(t, sink.letters[0].Attempts, tc.expectedTries)
+			}
+		})
+	}
+}
+
+func Test_WithRetry_Cancelled(t *testing.T) {
+	// Arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	unavailable := &statusError{StatusCode: http.StatusServiceUnavailable}
+
+	// Act
+	calls := 0
+	attempts, err := withRetry(ctx, func(context.Context) error {
+		calls++
+		cancel()
+		return unavailable
+	})
+
+	// Assert
+	require.Equal(t, unavailable, err)
+	require.Len(t, attempts, 1)
+	require.Equal(t, 1, calls)
+}
@@ -0,0 +1,116 @@
+package ingester
+
+import (
+	"net/http"
+	"path"
+)
+
+// webhook is an inbound request as seen by routing and filtering rules.
+type webhook struct {
+	header    http.Header
+	eventType string
+
+	// body is the decoded payload, nil when it isn't JSON.
+	body interface{}
+}
+
+func newWebhook(r *http.Request, payload []byte, eventType string) *webhook {
+	w := &webhook{header: r.Header, eventType: eventType}
+	if err := unmarshalJSON(payload, &w.body); err != nil {
+		w.body = nil
+	}
+
+	return w
+}
+
+// Matches reports whether the webhook satisfies every condition in m.
+func (m *MatchConfig) Matches(w *webhook) bool {
+	if len(m.EventTypes) != 0 && !matchAny(m.EventTypes, w.eventType) {
+		return false
+	}
+
+	for k, v := range m.Headers {
+		if w.header.Get(k) != v {
+			return false
+		}
+	}
+
+	for p, v := range m.Fields {
+		fv, ok := lookupPath(w.body, p)
+		if !ok {
+			return false
+		}
+
+		s, ok := stringify(fv)
+		if !ok || s != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appRouter resolves the Convoy apps a webhook is delivered to.
+type appRouter struct {
+	appID  string
+	routes []RouteConfig
+}
+
+// AppIDs returns the apps of every matching route, or the provider's default
+// app when no route matches.
+func (rt *appRouter) AppIDs(w *webhook) []string {
+	var appIDs []string
+	seen := make(map[string]bool)
+
+	for _, route := range rt.routes {
+		if !route.Match.Matches(w) {
+			continue
+		}
+
+		for _, id := range route.AppIDs {
+			if !seen[id] {
+				seen[id] = true
+				appIDs = append(appIDs, id)
+			}
+		}
+	}
+
+	if len(appIDs) == 0 && len(rt.appID) != 0 {
+		appIDs = append(appIDs, rt.appID)
+	}
+
+	return appIDs
+}
+
+// Accepts reports whether the webhook passes the provider's filters.
+func (f *FilterConfig) Accepts(w *webhook) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Allow) != 0 && !matchesAny(f.Allow, w) {
+		return false
+	}
+
+	return !matchesAny(f.Deny, w)
+}
+
+func matchesAny(rules []MatchConfig, w *webhook) bool {
+	for i := range rules {
+		if rules[i].Matches(w) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,157 @@
+package ingester
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Router_AppIDs(t *testing.T) {
+	routes := []RouteConfig{
+		{
+			AppIDs: []string{"live-app"},
+			Match: MatchConfig{
+				Fields: map[string]string{"$.livemode": "true"},
+			},
+		},
+		{
+			AppIDs: []string{"test-app"},
+			Match: MatchConfig{
+				Fields: map[string]string{"$.livemode": "false"},
+			},
+		},
+		{
+			AppIDs: []string{"billing-app", "live-app"},
+			Match: MatchConfig{
+				EventTypes: []string{"invoice.*"},
+			},
+		},
+		{
+			AppIDs: []string{"guild-app"},
+			Match: MatchConfig{
+				Fields: map[string]string{"$.amount": "5000", "$.guild_id": "820982911946154508"},
+			},
+		},
+		{
+			AppIDs: []string{"connect-app"},
+			Match: MatchConfig{
+				Headers: map[string]string{"Stripe-Account": "acct_123"},
+			},
+		},
+	}
+
+	tests := map[string]struct {
+		payload        string
+		eventType      string
+		headers        map[string]string
+		expectedAppIDs []string
+	}{
+		"payload_field": {
+			payload:        `{"livemode": false}`,
+			eventType:      "charge.succeeded",
+			expectedAppIDs: []string{"test-app"},
+		},
+		"fan_out": {
+			payload:        `{"livemode": true}`,
+			eventType:      "invoice.paid",
+			expectedAppIDs: []string{"live-app", "billing-app"},
+		},
+		"header": {
+			payload:        `{}`,
+			eventType:      "charge.succeeded",
+			headers:        map[string]string{"Stripe-Account": "acct_123"},
+			expectedAppIDs: []string{"connect-app"},
+		},
+		"number_field": {
+			payload:        `{"amount": 5000.0, "guild_id": 820982911946154508}`,
+			eventType:      "charge.succeeded",
+			expectedAppIDs: []string{"guild-app"},
+		},
+		"default_app": {
+			payload:        `{}`,
+			eventType:      "charge.succeeded",
+			expectedAppIDs: []string{"default-app"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			rt := &appRouter{appID: "default-app", routes: routes}
+
+			req, err := http.NewRequest("POST", "URL", strings.NewReader(tc.payload))
+			require.NoError(t, err)
+
+			for k, v := range tc.headers {
+				req.Header.Add(k, v)
+			}
+
+			// Act
+			appIDs := rt.AppIDs(newWebhook(req, []byte(tc.payload), tc.eventType))
+
+			// Assert
+			require.Equal(t, tc.expectedAppIDs, appIDs)
+		})
+	}
+}
+
+func Test_FilterConfig_Accepts(t *testing.T) {
+	tests := map[string]struct {
+		filters          *FilterConfig
+		payload          string
+		eventType        string
+		expectedAccepted bool
+	}{
+		"no_filters": {
+			filters:          nil,
+			eventType:        "customer.updated",
+			expectedAccepted: true,
+		},
+		"denied_event_type": {
+			filters: &FilterConfig{
+				Deny: []MatchConfig{{EventTypes: []string{"*.updated"}}},
+			},
+			eventType:        "customer.updated",
+			expectedAccepted: false,
+		},
+		"not_denied_event_type": {
+			filters: &FilterConfig{
+				Deny: []MatchConfig{{EventTypes: []string{"*.updated"}}},
+			},
+			eventType:        "customer.created",
+			expectedAccepted: true,
+		},
+		"not_allowed": {
+			filters: &FilterConfig{
+				Allow: []MatchConfig{{EventTypes: []string{"charge.*", "invoice.*"}}},
+			},
+			eventType:        "customer.created",
+			expectedAccepted: false,
+		},
+		"allowed_but_denied_by_payload": {
+			filters: &FilterConfig{
+				Allow: []MatchConfig{{EventTypes: []string{"charge.*"}}},
+				Deny:  []MatchConfig{{Fields: map[string]string{"$.livemode": "false"}}},
+			},
+			payload:          `{"livemode": false}`,
+			eventType:        "charge.succeeded",
+			expectedAccepted: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			req, err := http.NewRequest("POST", "URL", strings.NewReader(tc.payload))
+			require.NoError(t, err)
+
+			// Act
+			accepted := tc.filters.Accepts(newWebhook(req, []byte(tc.payload), tc.eventType))
+
+			// Assert
+			require.Equal(t, tc.expectedAccepted, accepted)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package ingester
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+var ErrScriptRejected = errors.New("Rejected by script")
+var ErrInvalidScriptResult = errors.New("Script must return None or a dict")
+
+const (
+	defaultScriptMaxSteps = 1000000
+	defaultScriptTimeout  = time.Second
+)
+
+// scriptModules are available to every provider script.
+var scriptModules = starlark.StringDict{
+	"json": json.Module,
+	"crypto": &starlarkstruct.Module{
+		Name: "crypto",
+		Members: starlark.StringDict{
+			"hmac":  starlark.NewBuiltin("crypto.hmac", scriptHmac),
+			"equal": starlark.NewBuiltin("crypto.equal", scriptEqual),
+		},
+	},
+}
+
+// script runs a provider's Starlark handle(request) function. The function
+// may return None to accept the webhook unchanged, or a dict with any of:
+//
+//	reject:     reason to refuse the webhook
+//	body:       replacement payload
+//	event_type: event type, overriding the event_type config
+type script struct {
+	handle   starlark.Value
+	maxSteps uint64
+	timeout  time.Duration
+}
+
+// scriptResult holds the changes a script made to a webhook.
+type scriptResult struct {
+	Body      []byte
+	EventType string
+}
+
+func newScript(name string, c *ScriptConfig) (*script, error) {
+	src := c.Source
+	if len(c.File) != 0 {
+		b, err := ioutil.ReadFile(c.File)
+		if err != nil {
+			return nil, err
+		}
+		src = string(b)
+	}
+
+	s := &script{maxSteps: c.MaxSteps, timeout: defaultScriptTimeout}
+	if s.maxSteps == 0 {
+		s.maxSteps = defaultScriptMaxSteps
+	}
+
+	if len(c.Timeout) != 0 {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		s.timeout = d
+	}
+
+	thread := s.newThread(name)
+	globals, err := starlark.ExecFile(thread, name+".star", src, scriptModules)
+	if err != nil {
+		return nil, err
+	}
+	globals.Freeze()
+
+	handle, ok := globals["handle"].(*starlark.Function)
+	if !ok {
+		return nil, fmt.Errorf("%s: script must define handle(request)", name)
+	}
+	s.handle = handle
+
+	return s, nil
+}
+
+func (s *script) newThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{Name: name}
+	thread.SetMaxExecutionSteps(s.maxSteps)
+	return thread
+}
+
+// Run calls handle with the request's method, url, headers and body.
+// Header names are lower-cased.
+func (s *script) Run(r *http.Request, payload []byte) (*scriptResult, error) {
+	headers := starlark.NewDict(len(r.Header))
+	for k := range r.Header {
+		headers.SetKey(starlark.String(strings.ToLower(k)), starlark.String(r.Header.Get(k)))
+	}
+
+	request := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"method":  starlark.String(r.Method),
+		"url":     starlark.String(r.URL.String()),
+		"headers": headers,
+		"body":    starlark.String(payload),
+	})
+
+	thread := s.newThread("handle")
+	timer := time.AfterFunc(s.timeout, func() {
+		thread.Cancel("timeout")
+	})
+	defer timer.Stop()
+
+	v, err := starlark.Call(thread, s.handle, starlark.Tuple{request}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return toScriptResult(v)
+}
+
+func toScriptResult(v starlark.Value) (*scriptResult, error) {
+	result := &scriptResult{}
+	if v == starlark.None {
+		return result, nil
+	}
+
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return nil, ErrInvalidScriptResult
+	}
+
+	get := func(key string) (string, bool, error) {
+		val, found, err := d.Get(starlark.String(key))
+		if err != nil || !found || val == starlark.None {
+			return "", false, err
+		}
+
+		str, ok := starlark.AsString(val)
+		if !ok {
+			return "", false, fmt.Errorf("%w: %s must be a string", ErrInvalidScriptResult, key)
+		}
+		return str, true, nil
+	}
+
+	reason, ok, err := get("reject")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, fmt.Errorf("%w: %s", ErrScriptRejected, reason)
+	}
+
+	body, ok, err := get("body")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		result.Body = []byte(body)
+	}
+
+	result.EventType, _, err = get("event_type")
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// scriptHmac implements crypto.hmac(hash, key, message, encoding="hex").
+func scriptHmac(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var algo, key, msg string
+	encoding := "hex"
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "hash", &algo, "key", &key, "message", &msg, "encoding?", &encoding); err != nil {
+		return nil, err
+	}
+
+	hash, err := getHashFunction(strings.ToUpper(algo))
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(hash, []byte(key))
+	mac.Write([]byte(msg))
+	sum := mac.Sum(nil)
+
+	switch encoding {
+	case "hex":
+		return starlark.String(hex.EncodeToString(sum)), nil
+	case "base64":
+		return starlark.String(base64.StdEncoding.EncodeToString(sum)), nil
+	default:
+		return nil, fmt.Errorf("%s: unknown encoding %q", b.Name(), encoding)
+	}
+}
+
+// scriptEqual implements crypto.equal(a, b), a constant time comparison.
+func scriptEqual(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "a", &x, "b", &y); err != nil {
+		return nil, err
+	}
+
+	return starlark.Bool(hmac.Equal([]byte(x), []byte(y))), nil
+}
@@ -0,0 +1,135 @@
+package ingester
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testScript = `
+def handle(request):
+    expected = crypto.hmac("sha256", "Partner Secret", request.headers["x-partner-timestamp"] + request.body)
+    if not crypto.equal(expected, request.headers.get("x-partner-signature", "")):
+        return {"reject": "bad signature"}
+
+    body = json.decode(request.body)
+    body.pop("card_number", None)
+    return {"event_type": "partner." + body["kind"], "body": json.encode(body)}
+`
+
+func Test_Script_Run(t *testing.T) {
+	tests := map[string]struct {
+		config            *ScriptConfig
+		headers           map[string]string
+		expectedEventType string
+		expectedBody      string
+		expectedError     error
+	}{
+		"valid_request": {
+			config: &ScriptConfig{Source: testScript},
+			headers: map[string]string{
+				"X-Partner-Timestamp": "1660000000",
+				"X-Partner-Signature": "4b6be9a5e261d306f290b61febb8c4cd8cf872f867cc87beb9b915d79689db28",
+			},
+			expectedEventType: "partner.payment",
+			expectedBody:      `{"amount":100,"kind":"payment"}`,
+		},
+		"invalid_signature": {
+			config: &ScriptConfig{Source: testScript},
+			headers: map[string]string{
+				"X-Partner-Timestamp": "1660000000",
+				"X-Partner-Signature": "wrong",
+			},
+			expectedError: ErrScriptRejected,
+		},
+		"no_changes": {
+			config:       &ScriptConfig{Source: "def handle(request):\n    return None\n"},
+			expectedBody: "",
+		},
+		"invalid_result": {
+			config:        &ScriptConfig{Source: "def handle(request):\n    return 1\n"},
+			expectedError: ErrInvalidScriptResult,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			s, err := newScript("partner", tc.config)
+			require.NoError(t, err)
+
+			payload := `{"kind": "payment", "amount": 100, "card_number": "4242424242424242"}`
+			req, err := http.NewRequest("POST", "URL", strings.NewReader(payload))
+			require.NoError(t, err)
+
+			for k, v := range tc.headers {
+				req.Header.Add(k, v)
+			}
+
+			// Act
+			result, err := s.Run(req, []byte(payload))
+
+			// Assert
+			require.ErrorIs(t, err, tc.expectedError)
+			if tc.expectedError != nil {
+				return
+			}
+
+			require.Equal(t, tc.expectedEventType, result.EventType)
+			require.Equal(t, tc.expectedBody, string(result.Body))
+		})
+	}
+}
+
+func Test_Script_Limits(t *testing.T) {
+	loop := "def handle(request):\n    for i in range(1000000000):\n        pass\n"
+
+	s, err := newScript("partner", &ScriptConfig{Source: loop, MaxSteps: 1000})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "URL", strings.NewReader(``))
+	require.NoError(t, err)
+
+	_, err = s.Run(req, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too many steps")
+}
+
+func Test_Provider_VerifyRequest_Script(t *testing.T) {
+	tests := map[string]struct {
+		script        string
+		expectedError error
+	}{
+		"script_without_verify": {
+			script:        `{"source": "def handle(request):\n    return None\n"}`,
+			expectedError: ErrVerifierNotConfigured,
+		},
+		"script_with_verify": {
+			script:        `{"source": "def handle(request):\n    return None\n", "verify": true}`,
+			expectedError: nil,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			t.Setenv(CONFIG_ENV, `[{"name": "partner", "app_id": "app-id", "script": `+tc.script+`}]`)
+			require.NoError(t, LoadConfig(CONFIG_ENV))
+			require.NoError(t, LoadProviderStore())
+
+			p, err := LookupProvider("partner")
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("POST", "URL", strings.NewReader(`{}`))
+			require.NoError(t, err)
+
+			// Act
+			err = p.VerifyRequest(req, []byte(`{}`))
+
+			// Assert
+			require.Equal(t, tc.expectedError, err)
+		})
+	}
+}
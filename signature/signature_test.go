@@ -0,0 +1,111 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Verifier_Verify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	payload := []byte(`{"id":302961}`)
+	hmacSigner := &HMACSigner{Secret: []byte("secret")}
+	edSigner := &Ed25519Signer{PrivateKey: priv}
+
+	tests := map[string]struct {
+		verifier    *Verifier
+		signature   string
+		timestamp   string
+		payload     []byte
+		expectedErr error
+	}{
+		"hmac": {
+			verifier:  &Verifier{Secret: []byte("secret")},
+			signature: hmacSigner.Sign(payload, now),
+			timestamp: "1654084800",
+			payload:   payload,
+		},
+		"ed25519": {
+			verifier:  &Verifier{PublicKey: pub},
+			signature: edSigner.Sign(payload, now),
+			timestamp: "1654084800",
+			payload:   payload,
+		},
+		"rotated keys": {
+			verifier:  &Verifier{Secret: []byte("secret")},
+			signature: (&HMACSigner{Secret: []byte("old")}).Sign(payload, now) + ", " + hmacSigner.Sign(payload, now),
+			timestamp: "1654084800",
+			payload:   payload,
+		},
+		"tampered payload": {
+			verifier:    &Verifier{Secret: []byte("secret")},
+			signature:   hmacSigner.Sign(payload, now),
+			timestamp:   "1654084800",
+			payload:     []byte(`{"id":302962}`),
+			expectedErr: ErrInvalidSignature,
+		},
+		"replayed timestamp": {
+			verifier:    &Verifier{Secret: []byte("secret")},
+			signature:   hmacSigner.Sign(payload, now),
+			timestamp:   "1654084801",
+			payload:     payload,
+			expectedErr: ErrInvalidSignature,
+		},
+		"expired": {
+			verifier:    &Verifier{Secret: []byte("secret")},
+			signature:   hmacSigner.Sign(payload, now.Add(-time.Hour)),
+			timestamp:   "1654081200",
+			payload:     payload,
+			expectedErr: ErrExpiredTimestamp,
+		},
+		"wrong scheme": {
+			verifier:    &Verifier{Secret: []byte("secret")},
+			signature:   edSigner.Sign(payload, now),
+			timestamp:   "1654084800",
+			payload:     payload,
+			expectedErr: ErrInvalidSignature,
+		},
+		"missing": {
+			verifier:    &Verifier{Secret: []byte("secret")},
+			timestamp:   "1654084800",
+			payload:     payload,
+			expectedErr: ErrMissingSignature,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			tc.verifier.Now = func() time.Time { return now }
+
+			// Act
+			err := tc.verifier.Verify(tc.payload, tc.signature, tc.timestamp)
+
+			// Assert
+			require.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
+
+func Test_Verifier_VerifyRequest(t *testing.T) {
+	// Arrange
+	payload := []byte(`{"id":302961}`)
+	r := httptest.NewRequest("POST", "/", strings.NewReader(string(payload)))
+	for k, v := range Headers(&HMACSigner{Secret: []byte("secret")}, payload, time.Now()) {
+		r.Header.Set(k, v)
+	}
+
+	// Act
+	body, err := (&Verifier{Secret: []byte("secret")}).VerifyRequest(r)
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, payload, body)
+}
@@ -0,0 +1,49 @@
+package ingester
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/frain-dev/convoy-ingester/signature"
+)
+
+var ErrInvalidSigningKey = errors.New("Signing key must be a base64 Ed25519 seed or private key")
+
+// newSignerFromEnv returns the signer for forwarded events: Ed25519 when
+// SIGNING_KEY_ENV is set, HMAC when SIGNING_SECRET_ENV is, or else nil.
+func newSignerFromEnv() (signature.Signer, error) {
+	if v := os.Getenv(SIGNING_KEY_ENV); len(v) != 0 {
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, ErrInvalidSigningKey
+		}
+
+		switch len(key) {
+		case ed25519.SeedSize:
+			return &signature.Ed25519Signer{PrivateKey: ed25519.NewKeyFromSeed(key)}, nil
+		case ed25519.PrivateKeySize:
+			return &signature.Ed25519Signer{PrivateKey: ed25519.PrivateKey(key)}, nil
+		default:
+			return nil, ErrInvalidSigningKey
+		}
+	}
+
+	if v := os.Getenv(SIGNING_SECRET_ENV); len(v) != 0 {
+		return &signature.HMACSigner{Secret: []byte(v)}, nil
+	}
+
+	return nil, nil
+}
+
+// signatureHeaders returns the ingester's signature headers for payload,
+// or nil when events aren't signed.
+func signatureHeaders(payload []byte) map[string]string {
+	if eventSigner == nil {
+		return nil
+	}
+
+	return signature.Headers(eventSigner, payload, time.Now())
+}
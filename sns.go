@@ -0,0 +1,294 @@
+package ingester
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidSNSMessage = errors.New("Invalid SNS message")
+var ErrSNSCertURLNotAllowed = errors.New("SNS signing certificate URL not allowed")
+var ErrSNSTopicNotAllowed = errors.New("SNS topic not allowed")
+var ErrSNSSignatureVersion = errors.New("Unsupported SNS signature version")
+
+// defaultSNSCertHost matches SNS's own hosts, the pattern AWS documents for
+// validating SigningCertURL.
+var defaultSNSCertHost = regexp.MustCompile(`^sns\.[a-zA-Z0-9\-]{3,}\.amazonaws\.com(\.cn)?$`)
+
+// CertFetcher retrieves the certificate SNS signed a message with.
+type CertFetcher interface {
+	Fetch(certURL string) (*x509.Certificate, error)
+}
+
+// httpCertFetcher downloads certificates and caches them by URL.
+type httpCertFetcher struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+func newHTTPCertFetcher(client *http.Client) *httpCertFetcher {
+	return &httpCertFetcher{client: client, certs: make(map[string]*x509.Certificate)}
+}
+
+func (f *httpCertFetcher) Fetch(certURL string) (*x509.Certificate, error) {
+	f.mu.Lock()
+	cert, ok := f.certs[certURL]
+	f.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	resp, err := f.client.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching SNS certificate: %s", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("SNS certificate is not PEM encoded")
+	}
+
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.certs[certURL] = cert
+	f.mu.Unlock()
+
+	return cert, nil
+}
+
+var defaultCertFetcher = newHTTPCertFetcher(&http.Client{Timeout: 10 * time.Second})
+
+// snsMessage is an SNS HTTP(S) delivery.
+// See https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageId        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+// canonicalString builds the string SNS signs for the message's type.
+func (m *snsMessage) canonicalString() string {
+	fields := [][2]string{
+		{"Message", m.Message},
+		{"MessageId", m.MessageId},
+	}
+
+	if m.Type == "Notification" {
+		if len(m.Subject) != 0 {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", m.SubscribeURL})
+	}
+
+	fields = append(fields, [2]string{"Timestamp", m.Timestamp})
+	if m.Type != "Notification" {
+		fields = append(fields, [2]string{"Token", m.Token})
+	}
+	fields = append(fields, [2]string{"TopicArn", m.TopicArn}, [2]string{"Type", m.Type})
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+
+	return b.String()
+}
+
+type SNSVerifier struct {
+	config  *SNSConfig
+	fetcher CertFetcher
+}
+
+func (sV *SNSVerifier) VerifyRequest(r *http.Request, payload []byte) error {
+	var m snsMessage
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return ErrInvalidSNSMessage
+	}
+
+	switch m.Type {
+	case "Notification", "SubscriptionConfirmation", "UnsubscribeConfirmation":
+	default:
+		return ErrInvalidSNSMessage
+	}
+
+	if len(sV.config.TopicARNs) != 0 && !matchAny(sV.config.TopicARNs, m.TopicArn) {
+		return ErrSNSTopicNotAllowed
+	}
+
+	if !sV.allowedURL(m.SigningCertURL) {
+		return ErrSNSCertURLNotAllowed
+	}
+
+	var hash crypto.Hash
+	var digest []byte
+	switch m.SignatureVersion {
+	case "1":
+		sum := sha1.Sum([]byte(m.canonicalString()))
+		hash, digest = crypto.SHA1, sum[:]
+	case "2":
+		sum := sha256.Sum256([]byte(m.canonicalString()))
+		hash, digest = crypto.SHA256, sum[:]
+	default:
+		return ErrSNSSignatureVersion
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return ErrCannotDecodeMACHeader
+	}
+
+	cert, err := sV.fetcher.Fetch(m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrInvalidSNSMessage
+	}
+
+	if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+		return ErrHashDoesNotMatch
+	}
+
+	return nil
+}
+
+// Unwrap returns a notification's Message, so only the publisher's payload
+// is forwarded. Messages that aren't JSON are forwarded as a JSON string.
+// Other message types are returned whole.
+func (sV *SNSVerifier) Unwrap(payload []byte) ([]byte, error) {
+	var m snsMessage
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return nil, ErrInvalidSNSMessage
+	}
+
+	if m.Type != "Notification" {
+		return payload, nil
+	}
+
+	if json.Valid([]byte(m.Message)) {
+		return []byte(m.Message), nil
+	}
+
+	return json.Marshal(m.Message)
+}
+
+// allowedURL reports whether u is an https URL on an allowed SNS host.
+func (sV *SNSVerifier) allowedURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Scheme != "https" {
+		return false
+	}
+
+	if len(sV.config.CertHosts) == 0 {
+		return defaultSNSCertHost.MatchString(parsed.Host)
+	}
+
+	for _, h := range sV.config.CertHosts {
+		if matchHost(h, parsed.Host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHost matches host against a glob pattern label by label, so a * can't
+// span dots and let in hosts under another domain.
+func matchHost(pattern, host string) bool {
+	patternLabels := strings.Split(pattern, ".")
+	hostLabels := strings.Split(host, ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+
+	for i, p := range patternLabels {
+		if ok, _ := path.Match(p, hostLabels[i]); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// snsHandshaker confirms subscriptions by visiting their SubscribeURL, and
+// acknowledges unsubscribe confirmations without publishing them.
+type snsHandshaker struct {
+	verifier *SNSVerifier
+	client   *http.Client
+}
+
+func (s *snsHandshaker) Respond(w http.ResponseWriter, r *http.Request, payload []byte) (bool, error) {
+	var m snsMessage
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return false, nil
+	}
+
+	switch m.Type {
+	case "SubscriptionConfirmation":
+		if !s.verifier.allowedURL(m.SubscribeURL) {
+			return true, ErrSNSCertURLNotAllowed
+		}
+
+		resp, err := s.client.Get(m.SubscribeURL)
+		if err != nil {
+			return true, err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return true, fmt.Errorf("confirming SNS subscription: %s", resp.Status)
+		}
+
+		w.Write([]byte("Subscription confirmed"))
+		return true, nil
+	case "UnsubscribeConfirmation":
+		w.Write([]byte("Unsubscribe acknowledged"))
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (s *snsHandshaker) Verified() bool { return true }
@@ -0,0 +1,232 @@
+package ingester
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSNSCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-01d088a6f77103d0fe307c0069e40ed6.pem"
+
+// stubCertFetcher serves a fixed certificate in place of SNS.
+type stubCertFetcher struct {
+	cert *x509.Certificate
+}
+
+func (s *stubCertFetcher) Fetch(certURL string) (*x509.Certificate, error) {
+	return s.cert, nil
+}
+
+func newTestSNSKey(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return key, cert
+}
+
+func signSNSMessage(t *testing.T, key *rsa.PrivateKey, m *snsMessage) []byte {
+	var hash crypto.Hash
+	var digest []byte
+	if m.SignatureVersion == "2" {
+		sum := sha256.Sum256([]byte(m.canonicalString()))
+		hash, digest = crypto.SHA256, sum[:]
+	} else {
+		sum := sha1.Sum([]byte(m.canonicalString()))
+		hash, digest = crypto.SHA1, sum[:]
+	}
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+	require.NoError(t, err)
+	m.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+	return b
+}
+
+func newTestSNSNotification() *snsMessage {
+	return &snsMessage{
+		Type:             "Notification",
+		MessageId:        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:         "arn:aws:sns:us-west-2:123456789012:MyTopic",
+		Subject:          "My First Message",
+		Message:          `{"event":"order.created"}`,
+		Timestamp:        "2012-05-02T00:54:06.655Z",
+		SignatureVersion: "1",
+		SigningCertURL:   testSNSCertURL,
+	}
+}
+
+func Test_SNSVerifier_VerifyRequest(t *testing.T) {
+	key, cert := newTestSNSKey(t)
+
+	tests := map[string]struct {
+		config        *SNSConfig
+		payloadFn     func(t *testing.T) []byte
+		expectedError error
+	}{
+		"valid_notification": {
+			config: &SNSConfig{},
+			payloadFn: func(t *testing.T) []byte {
+				return signSNSMessage(t, key, newTestSNSNotification())
+			},
+		},
+		"valid_notification_v2": {
+			config: &SNSConfig{},
+			payloadFn: func(t *testing.T) []byte {
+				m := newTestSNSNotification()
+				m.SignatureVersion = "2"
+				m.Subject = ""
+				return signSNSMessage(t, key, m)
+			},
+		},
+		"valid_subscription_confirmation": {
+			config: &SNSConfig{},
+			payloadFn: func(t *testing.T) []byte {
+				m := newTestSNSNotification()
+				m.Type = "SubscriptionConfirmation"
+				m.Token = "2336412f37"
+				m.SubscribeURL = "https://sns.us-west-2.amazonaws.com/?Action=ConfirmSubscription&Token=2336412f37"
+				return signSNSMessage(t, key, m)
+			},
+		},
+		"tampered_message": {
+			config: &SNSConfig{},
+			payloadFn: func(t *testing.T) []byte {
+				b := signSNSMessage(t, key, newTestSNSNotification())
+				return []byte(strings.Replace(string(b), "order.created", "order.deleted", 1))
+			},
+			expectedError: ErrHashDoesNotMatch,
+		},
+		"cert_url_not_allowed": {
+			config: &SNSConfig{},
+			payloadFn: func(t *testing.T) []byte {
+				m := newTestSNSNotification()
+				m.SigningCertURL = "https://attacker.example.com/cert.pem"
+				return signSNSMessage(t, key, m)
+			},
+			expectedError: ErrSNSCertURLNotAllowed,
+		},
+		"cert_url_in_s3_bucket": {
+			config: &SNSConfig{},
+			payloadFn: func(t *testing.T) []byte {
+				m := newTestSNSNotification()
+				m.SigningCertURL = "https://sns.evil.s3.amazonaws.com/cert.pem"
+				return signSNSMessage(t, key, m)
+			},
+			expectedError: ErrSNSCertURLNotAllowed,
+		},
+		"cert_url_in_s3_bucket_custom_hosts": {
+			config: &SNSConfig{CertHosts: []string{"sns.*.amazonaws.com"}},
+			payloadFn: func(t *testing.T) []byte {
+				m := newTestSNSNotification()
+				m.SigningCertURL = "https://sns.evil.s3.amazonaws.com/cert.pem"
+				return signSNSMessage(t, key, m)
+			},
+			expectedError: ErrSNSCertURLNotAllowed,
+		},
+		"cert_url_china": {
+			config: &SNSConfig{},
+			payloadFn: func(t *testing.T) []byte {
+				m := newTestSNSNotification()
+				m.SigningCertURL = "https://sns.cn-north-1.amazonaws.com.cn/cert.pem"
+				return signSNSMessage(t, key, m)
+			},
+		},
+		"topic_not_allowed": {
+			config: &SNSConfig{TopicARNs: []string{"arn:aws:sns:*:123456789012:Orders"}},
+			payloadFn: func(t *testing.T) []byte {
+				return signSNSMessage(t, key, newTestSNSNotification())
+			},
+			expectedError: ErrSNSTopicNotAllowed,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			v := &SNSVerifier{tc.config, &stubCertFetcher{cert}}
+			payload := tc.payloadFn(t)
+
+			req, err := http.NewRequest("POST", "URL", strings.NewReader(``))
+			require.NoError(t, err)
+
+			// Act
+			err = v.VerifyRequest(req, payload)
+
+			// Assert
+			require.ErrorIs(t, err, tc.expectedError)
+		})
+	}
+}
+
+func Test_SNSVerifier_Unwrap(t *testing.T) {
+	v := &SNSVerifier{&SNSConfig{}, nil}
+
+	m := newTestSNSNotification()
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+
+	out, err := v.Unwrap(b)
+	require.NoError(t, err)
+	require.Equal(t, `{"event":"order.created"}`, string(out))
+
+	m.Message = "plain text"
+	b, err = json.Marshal(m)
+	require.NoError(t, err)
+
+	out, err = v.Unwrap(b)
+	require.NoError(t, err)
+	require.Equal(t, `"plain text"`, string(out))
+}
+
+func Test_SNSHandshaker_Respond(t *testing.T) {
+	confirmed := false
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		confirmed = r.URL.Query().Get("Token") == "2336412f37"
+	}))
+	defer srv.Close()
+
+	v := &SNSVerifier{&SNSConfig{CertHosts: []string{"127.0.0.1:*"}}, nil}
+	h := &snsHandshaker{verifier: v, client: srv.Client()}
+
+	m := newTestSNSNotification()
+	m.Type = "SubscriptionConfirmation"
+	m.SubscribeURL = srv.URL + "/?Action=ConfirmSubscription&Token=2336412f37"
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handled, err := h.Respond(rec, httptest.NewRequest("POST", "/", nil), b)
+
+	require.NoError(t, err)
+	require.True(t, handled)
+	require.True(t, confirmed)
+}
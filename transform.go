@@ -0,0 +1,139 @@
+package ingester
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+var ErrInvalidTransform = errors.New("Invalid transform step")
+
+var transformFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// transformer reshapes payloads with the provider's transform steps, in order.
+type transformer struct {
+	steps     []TransformConfig
+	templates []*template.Template
+}
+
+func newTransformer(steps []TransformConfig) (*transformer, error) {
+	t := &transformer{
+		steps:     steps,
+		templates: make([]*template.Template, len(steps)),
+	}
+
+	for i, s := range steps {
+		switch s.Op {
+		case "remove", "rename", "wrap", "set", "template":
+		default:
+			return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidTransform, s.Op)
+		}
+
+		if len(s.Template) == 0 {
+			continue
+		}
+
+		tmpl, err := template.New(s.Op).Funcs(transformFuncs).Option("missingkey=zero").Parse(s.Template)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTransform, err)
+		}
+		t.templates[i] = tmpl
+	}
+
+	return t, nil
+}
+
+// Transform applies every step to payload. The payload is returned as is
+// when there are no steps.
+func (t *transformer) Transform(payload []byte) ([]byte, error) {
+	if len(t.steps) == 0 {
+		return payload, nil
+	}
+
+	doc, err := decodeJSON(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range t.steps {
+		doc, err = t.apply(doc, s, t.templates[i])
+		if err != nil {
+			return nil, fmt.Errorf("transform step %d (%s): %w", i, s.Op, err)
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+func (t *transformer) apply(doc interface{}, s TransformConfig, tmpl *template.Template) (interface{}, error) {
+	switch s.Op {
+	case "remove":
+		return doc, deletePath(doc, s.Path)
+	case "rename":
+		v, ok := lookupPath(doc, s.Path)
+		if !ok {
+			return doc, nil
+		}
+
+		if err := deletePath(doc, s.Path); err != nil {
+			return nil, err
+		}
+		return setPath(doc, s.To, v)
+	case "wrap":
+		return map[string]interface{}{s.Key: doc}, nil
+	case "set":
+		if tmpl == nil {
+			return setPath(doc, s.Path, s.Value)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, doc); err != nil {
+			return nil, err
+		}
+		return setPath(doc, s.Path, buf.String())
+	case "template":
+		if tmpl == nil {
+			return nil, ErrInvalidTransform
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, doc); err != nil {
+			return nil, err
+		}
+		out, err := decodeJSON(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%w: template output is not JSON - %v", ErrInvalidTransform, err)
+		}
+		return out, nil
+	default:
+		return nil, ErrInvalidTransform
+	}
+}
+
+// decodeJSON decodes data keeping numbers as json.Number, so large IDs
+// survive a round trip.
+func decodeJSON(data []byte) (interface{}, error) {
+	var doc interface{}
+	if err := unmarshalJSON(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// unmarshalJSON is json.Unmarshal keeping numbers as json.Number.
+func unmarshalJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,80 @@
+package ingester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Transformer_Transform(t *testing.T) {
+	payload := `{"event":"charge.success","data":{"id":30296184562134567,"customer":{"email":"jon@doe.ca","code":"CUS_1"}}}`
+
+	tests := map[string]struct {
+		steps           []TransformConfig
+		expectedPayload string
+		expectedError   error
+	}{
+		"no_steps": {
+			steps:           nil,
+			expectedPayload: payload,
+		},
+		"remove": {
+			steps: []TransformConfig{
+				{Op: "remove", Path: "$.data.customer.email"},
+				{Op: "remove", Path: "$.data.missing.field"},
+			},
+			expectedPayload: `{"data":{"customer":{"code":"CUS_1"},"id":30296184562134567},"event":"charge.success"}`,
+		},
+		"rename": {
+			steps: []TransformConfig{
+				{Op: "rename", Path: "$.data.customer.code", To: "$.customer_code"},
+			},
+			expectedPayload: `{"customer_code":"CUS_1","data":{"customer":{"email":"jon@doe.ca"},"id":30296184562134567},"event":"charge.success"}`,
+		},
+		"set_and_wrap": {
+			steps: []TransformConfig{
+				{Op: "set", Path: "$.source", Value: "paystack"},
+				{Op: "set", Path: "$.event", Template: `{{ upper .event }}`},
+				{Op: "wrap", Key: "payload"},
+			},
+			expectedPayload: `{"payload":{"data":{"customer":{"code":"CUS_1","email":"jon@doe.ca"},"id":30296184562134567},"event":"CHARGE.SUCCESS","source":"paystack"}}`,
+		},
+		"template": {
+			steps: []TransformConfig{
+				{Op: "template", Template: `{"type": {{ json .event }}, "id": {{ .data.id }}}`},
+			},
+			expectedPayload: `{"id":30296184562134567,"type":"charge.success"}`,
+		},
+		"invalid_template_output": {
+			steps: []TransformConfig{
+				{Op: "template", Template: `{{ .event }}`},
+			},
+			expectedError: ErrInvalidTransform,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			tr, err := newTransformer(tc.steps)
+			require.NoError(t, err)
+
+			// Act
+			out, err := tr.Transform([]byte(payload))
+
+			// Assert
+			if tc.expectedError != nil {
+				require.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPayload, string(out))
+		})
+	}
+}
+
+func Test_NewTransformer_UnknownOp(t *testing.T) {
+	_, err := newTransformer([]TransformConfig{{Op: "explode"}})
+	require.ErrorIs(t, err, ErrInvalidTransform)
+}
@@ -16,11 +16,75 @@ type publishRequest struct {
 // See the documentation for more details:
 // https://cloud.google.com/pubsub/docs/reference/rest/v1/PubsubMessage
 type pubSubMessage struct {
-	Data []byte `json:"data"`
+	Data       []byte            `json:"data"`
+	Attributes map[string]string `json:"attributes"`
 }
 
+// Attributes of published events. Subscribers can filter on them, and
+// PushToConvoy reads the event's routing from them instead of the data.
+// Where an event is forwarded to is never read from a message; it comes
+// from the config of the event's provider.
+const (
+	attrProvider       = "provider"
+	attrEventType      = "event_type"
+	attrAppID          = "app_id"
+	attrRequestID      = "request_id"
+	attrIdempotencyKey = "idempotency_key"
+	attrFormat         = "format"
+)
+
 type convoyRequest struct {
 	Data convoyModels.EventRequest `json:"data"`
+
+	// Provider received the webhook, and RequestID identifies the inbound
+	// request. Both are only set on messages with attributes.
+	Provider  string `json:"provider,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// IdempotencyKey identifies the provider's delivery, so redelivered
+	// messages are pushed to Convoy once.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// Attributes returns the message attributes the request is published with.
+func (c *convoyRequest) Attributes(format string) map[string]string {
+	attrs := map[string]string{
+		attrProvider:  c.Provider,
+		attrEventType: c.Data.Event,
+		attrAppID:     c.Data.AppID,
+		attrRequestID: c.RequestID,
+	}
+
+	if len(c.IdempotencyKey) != 0 {
+		attrs[attrIdempotencyKey] = c.IdempotencyKey
+	}
+
+	if len(format) != 0 {
+		attrs[attrFormat] = format
+	}
+
+	return attrs
+}
+
+// FromMessage reads a queued event. Messages published with attributes
+// carry the event's data as is; older messages hold an encoded
+// convoyRequest or CloudEvent.
+func (c *convoyRequest) FromMessage(m pubSubMessage) error {
+	if appID, ok := m.Attributes[attrAppID]; ok {
+		c.Data.AppID = appID
+		c.Data.Event = m.Attributes[attrEventType]
+		c.Data.Data = m.Data
+		c.IdempotencyKey = m.Attributes[attrIdempotencyKey]
+		c.Provider = m.Attributes[attrProvider]
+		c.RequestID = m.Attributes[attrRequestID]
+		return nil
+	}
+
+	if isCloudEvent(m.Data) {
+		return c.FromCloudEvent(m.Data)
+	}
+
+	return c.FromBytes(m.Data)
 }
 
 func (c *convoyRequest) ToBytes() ([]byte, error) {
@@ -0,0 +1,121 @@
+package ingester
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	convoyModels "github.com/frain-dev/convoy-go/models"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ConvoyRequest_FromMessage(t *testing.T) {
+	legacy, err := (&convoyRequest{
+		Data: convoyModels.EventRequest{
+			AppID: "app-id",
+			Event: "charge.success",
+			Data:  []byte(`{"id":302961}`),
+		},
+		IdempotencyKey: "paystack:302961",
+	}).ToBytes()
+	require.NoError(t, err)
+
+	attrs := (&convoyRequest{
+		Data: convoyModels.EventRequest{
+			AppID: "app-id",
+			Event: "charge.success",
+		},
+		Provider:       "paystack",
+		RequestID:      "req-1",
+		IdempotencyKey: "paystack:302961",
+	}).Attributes("")
+
+	tests := map[string]struct {
+		message pubSubMessage
+	}{
+		"attributes": {
+			message: pubSubMessage{Data: []byte(`{"id":302961}`), Attributes: attrs},
+		},
+		"encoded request": {
+			message: pubSubMessage{Data: legacy},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Act
+			req := &convoyRequest{}
+			err := req.FromMessage(tc.message)
+
+			// Assert
+			require.NoError(t, err)
+			require.Equal(t, "app-id", req.Data.AppID)
+			require.Equal(t, "charge.success", req.Data.Event)
+			require.JSONEq(t, `{"id":302961}`, string(req.Data.Data))
+			require.Equal(t, "paystack:302961", req.IdempotencyKey)
+		})
+	}
+}
+
+func Test_ConvoyRequest_Attributes(t *testing.T) {
+	req := &convoyRequest{
+		Data: convoyModels.EventRequest{
+			AppID: "app-id",
+			Event: "charge.success",
+		},
+		Provider:  "paystack",
+		RequestID: "req-1",
+	}
+
+	require.Equal(t, map[string]string{
+		"provider":   "paystack",
+		"event_type": "charge.success",
+		"app_id":     "app-id",
+		"request_id": "req-1",
+		"format":     "cloudevents",
+	}, req.Attributes("cloudevents"))
+}
+
+func Test_Provider_OrderingKey(t *testing.T) {
+	tests := map[string]struct {
+		orderingKey string
+		expectedKey string
+	}{
+		"no ordering key": {
+			orderingKey: "",
+			expectedKey: "",
+		},
+		"provider and app": {
+			orderingKey: "{provider}:{app_id}",
+			expectedKey: "paystack:app-id",
+		},
+		"path and header": {
+			orderingKey: "{event}:{path:$.data.customer.id}:{header:X-Shop}",
+			expectedKey: "charge.success:84312:shop-1",
+		},
+		"large integer path": {
+			orderingKey: "{path:$.data.guild_id}",
+			expectedKey: "820982911946154508",
+		},
+		"missing path": {
+			orderingKey: "{path:$.data.order}",
+			expectedKey: "",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			p := &Provider{Name: "paystack", orderingKey: tc.orderingKey}
+			req := httptest.NewRequest("POST", "/", strings.NewReader(""))
+			req.Header.Set("X-Shop", "shop-1")
+			wh := newWebhook(req, []byte(`{"data":{"customer":{"id":84312},"guild_id":820982911946154508}}`), "charge.success")
+
+			// Act
+			key := p.OrderingKey(wh, "app-id")
+
+			// Assert
+			require.Equal(t, tc.expectedKey, key)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package ingester
 import (
 	"bytes"
 	"crypto/hmac"
+	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
@@ -11,7 +12,11 @@ import (
 	"hash"
 	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var ErrAlgoNotFound = errors.New("Algorithm not found")
@@ -24,6 +29,11 @@ var ErrAuthHeader = errors.New("Invalid Authorization header")
 var ErrAuthHeaderCannotBeEmpty = errors.New("Auth header cannot be empty")
 var ErrInvalidHeaderStructure = errors.New("Invalid header structure")
 var ErrInvalidAuthLength = errors.New("Invalid Basic Auth Length")
+var ErrInvalidTimestamp = errors.New("Invalid signature timestamp")
+var ErrTimestampOutOfTolerance = errors.New("Signature timestamp is outside the tolerance")
+
+// defaultHmacTolerance is how far a signature's timestamp may be from now.
+const defaultHmacTolerance = 5 * time.Minute
 
 type Verifier interface {
 	VerifyRequest(r *http.Request, payload []byte) error
@@ -31,6 +41,9 @@ type Verifier interface {
 
 type HmacVerifier struct {
 	config *HmacConfig
+
+	// now is the clock timestamps are checked against, time.Now if nil.
+	now func() time.Time
 }
 
 func (hV *HmacVerifier) VerifyRequest(r *http.Request, payload []byte) error {
@@ -46,28 +59,192 @@ func (hV *HmacVerifier) VerifyRequest(r *http.Request, payload []byte) error {
 
 	rHeader := r.Header.Get(hV.config.Header)
 
-	if len(strings.TrimSpace(rHeader)) == 0 {
+	var params map[string][]string
+	var signatures []string
+	if len(hV.config.SignatureKey) != 0 {
+		params = parseSignatureHeader(rHeader)
+		signatures = params[hV.config.SignatureKey]
+	} else if s := strings.TrimPrefix(rHeader, hV.config.Prefix); len(strings.TrimSpace(s)) != 0 {
+		signatures = []string{s}
+	}
+
+	if len(signatures) == 0 {
 		return ErrSignatureCannotBeEmpty
 	}
 
+	if err := hV.checkTimestamp(r, payload, params); err != nil {
+		return err
+	}
+
 	mac := hmac.New(hash, []byte(hV.config.Secret))
-	mac.Write(payload)
+	mac.Write(hV.signedPayload(r, payload, params))
 	eMAC := mac.Sum(nil)
-	sMAC, err := hex.DecodeString(rHeader)
-	if err != nil {
+
+	decoded := false
+	for _, s := range signatures {
+		sMAC, err := hV.decodeSignature(s)
+		if err != nil {
+			continue
+		}
+		decoded = true
+
+		if hmac.Equal(sMAC, eMAC) {
+			return nil
+		}
+	}
+
+	if !decoded {
 		return ErrCannotDecodeMACHeader
 	}
 
-	validMAC := hmac.Equal(sMAC, eMAC)
-	if !validMAC {
-		return ErrHashDoesNotMatch
+	return ErrHashDoesNotMatch
+}
+
+// parseSignatureHeader splits a header of comma-separated key=value pairs,
+// such as Stripe-Signature, keeping every value of repeated keys.
+func parseSignatureHeader(header string) map[string][]string {
+	params := map[string][]string{}
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) == 2 && len(kv[1]) != 0 {
+			params[kv[0]] = append(params[kv[0]], kv[1])
+		}
+	}
+
+	return params
+}
+
+// checkTimestamp rejects requests signed further than the tolerance from
+// now, when the provider signs a timestamp.
+func (hV *HmacVerifier) checkTimestamp(r *http.Request, payload []byte, params map[string][]string) error {
+	if len(hV.config.Timestamp) == 0 {
+		return nil
+	}
+
+	ts, err := strconv.ParseInt(strings.TrimSpace(hV.expand(hV.config.Timestamp, r, payload, params)), 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+
+	tolerance := defaultHmacTolerance
+	if len(hV.config.Tolerance) != 0 {
+		if tolerance, err = time.ParseDuration(hV.config.Tolerance); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now
+	if hV.now != nil {
+		now = hV.now
+	}
+
+	age := now().Sub(time.Unix(ts, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrTimestampOutOfTolerance
 	}
 
 	return nil
 }
 
+// signedPayload builds the content the provider signed from the configured
+// template, e.g. "v0:{header:X-Slack-Request-Timestamp}:{body}".
+func (hV *HmacVerifier) signedPayload(r *http.Request, payload []byte, params map[string][]string) []byte {
+	tmpl := hV.config.SignedPayload
+	if len(tmpl) == 0 {
+		return payload
+	}
+
+	return []byte(hV.expand(tmpl, r, payload, params))
+}
+
+// expand renders a template of the request's fields.
+func (hV *HmacVerifier) expand(tmpl string, r *http.Request, payload []byte, params map[string][]string) string {
+	return expandPlaceholders(tmpl, func(field string) (string, bool) {
+		switch {
+		case field == "body":
+			return string(payload), true
+		case strings.HasPrefix(field, "header:"):
+			return r.Header.Get(strings.TrimPrefix(field, "header:")), true
+		case strings.HasPrefix(field, "param:"):
+			if v := params[strings.TrimPrefix(field, "param:")]; len(v) != 0 {
+				return v[0], true
+			}
+			return "", true
+		case field == "url":
+			return hV.publicURL(r), true
+		case field == "form":
+			return sortedForm(payload), true
+		default:
+			return "", false
+		}
+	})
+}
+
+// publicURL rebuilds the URL the provider sent the request to.
+func (hV *HmacVerifier) publicURL(r *http.Request) string {
+	if len(hV.config.PublicURL) != 0 {
+		return strings.TrimSuffix(hV.config.PublicURL, "/") + r.URL.RequestURI()
+	}
+
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	if hV.config.ForwardedHeaders {
+		if p := r.Header.Get("X-Forwarded-Proto"); len(p) != 0 {
+			scheme = strings.TrimSpace(strings.Split(p, ",")[0])
+		}
+
+		if h := r.Header.Get("X-Forwarded-Host"); len(h) != 0 {
+			host = strings.TrimSpace(strings.Split(h, ",")[0])
+		}
+	}
+
+	return scheme + "://" + host + r.URL.RequestURI()
+}
+
+// sortedForm concatenates form parameters sorted by name, each name followed
+// by its values, as Twilio signs them.
+func sortedForm(payload []byte) string {
+	form, err := url.ParseQuery(string(payload))
+	if err != nil {
+		return ""
+	}
+
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range form[k] {
+			b.WriteString(k + v)
+		}
+	}
+
+	return b.String()
+}
+
+func (hV *HmacVerifier) decodeSignature(sig string) ([]byte, error) {
+	switch hV.config.Encoding {
+	case "base64":
+		return base64.StdEncoding.DecodeString(sig)
+	default:
+		return hex.DecodeString(sig)
+	}
+}
+
 func (hV *HmacVerifier) getHashFunction(algo string) (func() hash.Hash, error) {
+	return getHashFunction(algo)
+}
+
+func getHashFunction(algo string) (func() hash.Hash, error) {
 	switch algo {
+	case "SHA1":
+		return sha1.New, nil
 	case "SHA256":
 		return sha256.New, nil
 	case "SHA512":
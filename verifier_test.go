@@ -75,6 +75,25 @@ func Test_HmacVerifier_VerifyRequest(t *testing.T) {
 			},
 			expectedError: ErrSignatureCannotBeEmpty,
 		},
+		"valid_url_and_form_signature": {
+			opts: &HmacConfig{
+				Header:        "X-Twilio-Signature",
+				Hash:          "SHA1",
+				Secret:        "12345",
+				Encoding:      "base64",
+				SignedPayload: "{url}{form}",
+				PublicURL:     "https://mycompany.com",
+			},
+			payload: []byte("To=%2B18005551212&From=%2B12349013030&Digits=1234&Caller=%2B12349013030&CallSid=CA1234567890ABCDE"),
+			requestFn: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest("POST", "http://localhost:8080/myapp.php?foo=1&bar=2", strings.NewReader(``))
+				require.NoError(t, err)
+
+				req.Header.Add("X-Twilio-Signature", "0/KCTR6DLpKmkAf8muzZqo1nDgQ=")
+				return req
+			},
+			expectedError: nil,
+		},
 		"valid_request": {
 			opts: &HmacConfig{
 				Header: "X-Convoy-Signature",
@@ -100,7 +119,7 @@ func Test_HmacVerifier_VerifyRequest(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			// Arrange.
-			v := HmacVerifier{tc.opts}
+			v := HmacVerifier{config: tc.opts}
 			req := tc.requestFn(t)
 
 			// Assert.
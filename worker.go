@@ -0,0 +1,357 @@
+package ingester
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWorkerConcurrency = 10
+	defaultWorkerBatchSize   = 20
+	defaultWorkerBatchWait   = 500 * time.Millisecond
+
+	// defaultWorkerDrainTimeout fits in the usual 30s grace period between
+	// SIGTERM and SIGKILL.
+	defaultWorkerDrainTimeout = 25 * time.Second
+
+	// prepareRetryDelay is how long a message that couldn't be prepared,
+	// such as a copy of an event still in flight, is held before it is
+	// nacked, so Pub/Sub doesn't redeliver it straight away.
+	prepareRetryDelay = 10 * time.Second
+)
+
+// WorkerConfig tunes RunWorker.
+type WorkerConfig struct {
+	// Concurrency is how many forwards run at once.
+	Concurrency int
+
+	// BatchSize is how many messages are collected before they are
+	// forwarded, waiting at most BatchWait for a batch to fill.
+	BatchSize int
+	BatchWait time.Duration
+
+	// DrainTimeout is how long forwards started before shutdown may run
+	// on once RunWorker's context is done.
+	DrainTimeout time.Duration
+}
+
+// LoadWorkerConfig reads the worker's settings from the environment.
+func LoadWorkerConfig() (*WorkerConfig, error) {
+	c := &WorkerConfig{
+		Concurrency: defaultWorkerConcurrency,
+		BatchSize:   defaultWorkerBatchSize,
+		BatchWait:   defaultWorkerBatchWait,
+
+		DrainTimeout: defaultWorkerDrainTimeout,
+	}
+
+	durations := map[string]*time.Duration{
+		WORKER_BATCH_WAIT_ENV:    &c.BatchWait,
+		WORKER_DRAIN_TIMEOUT_ENV: &c.DrainTimeout,
+	}
+	for env, d := range durations {
+		if v := os.Getenv(env); len(v) != 0 {
+			var err error
+			if *d, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+
+	ints := map[string]*int{
+		WORKER_CONCURRENCY_ENV: &c.Concurrency,
+		WORKER_BATCH_SIZE_ENV:  &c.BatchSize,
+	}
+	for env, n := range ints {
+		if v := os.Getenv(env); len(v) != 0 {
+			var err error
+			if *n, err = strconv.Atoi(v); err != nil || *n < 1 {
+				return nil, fmt.Errorf("%s must be a positive number", env)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// RunWorker pulls messages from sub and forwards them until ctx is done,
+// as a long-running alternative to PushToConvoy.
+//
+// Messages are collected into batches. Deliveries to a target that takes
+// batches are sent together; the rest, including every Convoy target as
+// Convoy has no batch API, are forwarded one by one. At most
+// Concurrency forwards run at once, and each message is acknowledged or
+// nacked on its own. Messages with an ordering key are forwarded as they
+// arrive, so their order is kept.
+//
+// Each message's Receive callback returns once the message is settled, so
+// RunWorker only returns after every received message was acknowledged
+// or nacked. Forwards don't use ctx: once it is done, those already
+// received get DrainTimeout to finish.
+func RunWorker(ctx context.Context, sub *pubsub.Subscription, c *WorkerConfig) error {
+	w := &worker{
+		config:   c,
+		incoming: make(chan *queued),
+		sem:      make(chan struct{}, c.Concurrency),
+		stopping: ctx.Done(),
+	}
+
+	sub.ReceiveSettings.MaxOutstandingMessages = 2 * c.Concurrency * c.BatchSize
+
+	drain, cancel := drainContext(ctx, c.DrainTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.collect(drain)
+		close(done)
+	}()
+
+	err := sub.Receive(ctx, func(_ context.Context, m *pubsub.Message) {
+		q := &queued{m: m, done: make(chan struct{})}
+		if len(m.OrderingKey) != 0 {
+			w.dispatch(drain, []*queued{q})
+		} else {
+			w.incoming <- q
+		}
+
+		<-q.done
+	})
+
+	close(w.incoming)
+	<-done
+
+	return err
+}
+
+// drainContext returns a context that is cancelled d after ctx is done,
+// so work started before then can finish.
+func drainContext(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	drain, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-drain.Done():
+			return
+		}
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			cancel()
+		case <-drain.Done():
+		}
+	}()
+
+	return drain, cancel
+}
+
+type worker struct {
+	config   *WorkerConfig
+	incoming chan *queued
+
+	// sem bounds the forwards running at once.
+	sem chan struct{}
+
+	// stopping is closed when RunWorker's context is done, releasing held
+	// messages.
+	stopping <-chan struct{}
+}
+
+// queued is a received message. Its Receive callback waits for done,
+// which is closed once the message is settled.
+type queued struct {
+	m    *pubsub.Message
+	done chan struct{}
+}
+
+// settle acknowledges q's message, or nacks it so Pub/Sub redelivers it
+// when err is set.
+func (q *queued) settle(err error) {
+	defer close(q.done)
+
+	if err == nil {
+		q.m.Ack()
+		return
+	}
+
+	log.WithError(err).Errorf("Failed to deliver message %s", q.m.ID)
+	q.m.Nack()
+}
+
+// collect batches incoming messages until the channel is closed.
+func (w *worker) collect(ctx context.Context) {
+	var batch []*queued
+	timer := time.NewTimer(w.config.BatchWait)
+	timer.Stop()
+
+	flush := func() {
+		timer.Stop()
+		if len(batch) != 0 {
+			w.dispatch(ctx, batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case q, ok := <-w.incoming:
+			if !ok {
+				flush()
+				return
+			}
+
+			batch = append(batch, q)
+			if len(batch) >= w.config.BatchSize {
+				flush()
+			} else if len(batch) == 1 {
+				timer.Reset(w.config.BatchWait)
+			}
+
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// pending is a message being delivered.
+type pending struct {
+	q *queued
+	d *delivery
+}
+
+// dispatch starts forwarding msgs, waiting while Concurrency forwards are
+// running.
+func (w *worker) dispatch(ctx context.Context, msgs []*queued) {
+	batches := map[string][]pending{}
+	var targets []string
+
+	for _, q := range msgs {
+		d, err := prepareDelivery(pubSubMessage{Data: q.m.Data, Attributes: q.m.Attributes})
+		if d == nil {
+			if err != nil {
+				w.settleLater(q, err, prepareRetryDelay)
+			} else {
+				q.settle(nil)
+			}
+			continue
+		}
+
+		if _, ok := d.forwarder.(BatchForwarder); ok {
+			if _, ok := batches[d.target]; !ok {
+				targets = append(targets, d.target)
+			}
+			batches[d.target] = append(batches[d.target], pending{q, d})
+			continue
+		}
+
+		q := q
+		w.run(func() {
+			attempts, err := withRetry(ctx, func(ctx context.Context) error {
+				return d.forwarder.Forward(ctx, d.req)
+			})
+			w.settleAfterPause(q, d.finish(attempts, err))
+		})
+	}
+
+	// Batches are split to what the target's rate limit can let through.
+	for _, target := range targets {
+		batch := batches[target]
+		size := maxBatch(batch[0].d.forwarder)
+		if size == 0 {
+			size = len(batch)
+		}
+
+		for len(batch) != 0 {
+			n := size
+			if n > len(batch) {
+				n = len(batch)
+			}
+
+			chunk := batch[:n]
+			batch = batch[n:]
+			w.run(func() {
+				w.forwardBatch(ctx, chunk)
+			})
+		}
+	}
+}
+
+// run calls fn in a goroutine once a slot is free.
+func (w *worker) run(fn func()) {
+	w.sem <- struct{}{}
+
+	go func() {
+		defer func() { <-w.sem }()
+		fn()
+	}()
+}
+
+// forwardBatch sends deliveries to the same target in one request. When
+// the target rejects the batch, its events are forwarded one by one, so
+// only the events it rejects are dead-lettered.
+func (w *worker) forwardBatch(ctx context.Context, batch []pending) {
+	reqs := make([]*convoyRequest, len(batch))
+	for i, p := range batch {
+		reqs[i] = p.d.req
+	}
+
+	bf := batch[0].d.forwarder.(BatchForwarder)
+	attempts, err := withRetry(ctx, func(ctx context.Context) error {
+		return bf.ForwardBatch(ctx, reqs)
+	})
+
+	if len(batch) > 1 && isPermanent(err) {
+		log.WithError(err).Warnf("Batch of %d events rejected, forwarding them one by one", len(batch))
+		for _, p := range batch {
+			d := p.d
+			attempts, err := withRetry(ctx, func(ctx context.Context) error {
+				return d.forwarder.Forward(ctx, d.req)
+			})
+			w.settleAfterPause(p.q, d.finish(attempts, err))
+		}
+		return
+	}
+
+	for _, p := range batch {
+		w.settleAfterPause(p.q, p.d.finish(attempts, err))
+	}
+}
+
+// settleAfterPause settles q with err. When err paused q's target, q is
+// held until the pause is over or the worker stops, without taking a
+// forward slot, so Pub/Sub doesn't redeliver it straight away. Held
+// messages count against MaxOutstandingMessages, which slows pulling.
+func (w *worker) settleAfterPause(q *queued, err error) {
+	after, ok := pausedFor(err)
+	if !ok {
+		q.settle(err)
+		return
+	}
+
+	w.settleLater(q, err, after)
+}
+
+// settleLater settles q with err once after has passed or the worker
+// stops, whichever is first.
+func (w *worker) settleLater(q *queued, err error, after time.Duration) {
+	go func() {
+		timer := time.NewTimer(after)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-w.stopping:
+		}
+		q.settle(err)
+	}()
+}
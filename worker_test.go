@@ -0,0 +1,330 @@
+package ingester
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func Test_RunWorker(t *testing.T) {
+	// Arrange
+	var mu sync.Mutex
+	var batches [][]batchEvent
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []batchEvent
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &events)
+
+		mu.Lock()
+		batches = append(batches, events)
+		mu.Unlock()
+	}))
+	defer relay.Close()
+
+	var convoyCalls int
+	convoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		convoyCalls++
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":false,"message":"rejected"}`))
+	}))
+	defer convoy.Close()
+
+	f, err := newHTTPForwarder(&HTTPTargetConfig{URL: relay.URL, Batch: true})
+	require.NoError(t, err)
+
+	prevStore, prevAPI, prevSink := providerStore, convoyAPI, deadLetterSink
+	providerStore = ProviderStore{"relay": {Name: "relay", forwarder: f}}
+	convoyAPI = newConvoyClient(&ConvoyConfig{URL: convoy.URL})
+	sink := &recordingDeadLetterSink{}
+	deadLetterSink = sink
+	defer func() { providerStore, convoyAPI, deadLetterSink = prevStore, prevAPI, prevSink }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, topic, sub := newTestSubscription(t, ctx)
+
+	messages := []map[string]string{
+		{"provider": "relay", "app_id": "app-1", "event_type": "charge.success"},
+		{"provider": "relay", "app_id": "app-2", "event_type": "charge.success"},
+		{"provider": "other", "app_id": "app-3", "event_type": "charge.success"},
+	}
+	for _, attrs := range messages {
+		_, err := topic.Publish(ctx, &pubsub.Message{Data: []byte(`{"id":302961}`), Attributes: attrs}).Get(ctx)
+		require.NoError(t, err)
+	}
+
+	// Act
+	done := make(chan error)
+	go func() {
+		done <- RunWorker(ctx, sub, &WorkerConfig{Concurrency: 2, BatchSize: 3, BatchWait: time.Second})
+	}()
+
+	require.Eventually(t, func() bool {
+		for _, m := range srv.Messages() {
+			if m.Acks == 0 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// Assert
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 2)
+	require.ElementsMatch(t, []string{"app-1", "app-2"}, []string{batches[0][0].AppID, batches[0][1].AppID})
+
+	require.Equal(t, 1, convoyCalls)
+	require.Len(t, sink.letters, 1)
+	require.Equal(t, "app-3", sink.letters[0].Attributes["app_id"])
+}
+
+func Test_RunWorker_DrainsBeforeReturning(t *testing.T) {
+	// Arrange
+	received := make(chan struct{})
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+	}))
+	defer target.Close()
+	defer releaseOnce.Do(func() { close(release) })
+
+	f, err := newHTTPForwarder(&HTTPTargetConfig{URL: target.URL})
+	require.NoError(t, err)
+
+	prevStore := providerStore
+	providerStore = ProviderStore{"relay": {Name: "relay", forwarder: f}}
+	defer func() { providerStore = prevStore }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, topic, sub := newTestSubscription(t, ctx)
+	_, err = topic.Publish(ctx, &pubsub.Message{
+		Data:       []byte(`{"id":302961}`),
+		Attributes: map[string]string{"provider": "relay", "app_id": "app-1", "event_type": "charge.success"},
+	}).Get(ctx)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- RunWorker(ctx, sub, &WorkerConfig{Concurrency: 1, BatchSize: 1, BatchWait: time.Millisecond, DrainTimeout: 5 * time.Second})
+	}()
+	<-received
+
+	// Act: the forward in flight finishes after shutdown began.
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	releaseOnce.Do(func() { close(release) })
+	err = <-done
+
+	// Assert
+	require.NoError(t, err)
+	require.Equal(t, 1, srv.Messages()[0].Acks)
+}
+
+func Test_RunWorker_RejectedBatch(t *testing.T) {
+	// Arrange
+	var mu sync.Mutex
+	var batches, singles int
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Reject batches, and the single event with ID 2.
+		switch {
+		case body[0] == '[':
+			batches++
+			w.WriteHeader(http.StatusBadRequest)
+		case string(body) == `{"id":2}`:
+			singles++
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			singles++
+		}
+	}))
+	defer relay.Close()
+
+	f, err := newHTTPForwarder(&HTTPTargetConfig{URL: relay.URL, Batch: true})
+	require.NoError(t, err)
+
+	prevStore, prevSink := providerStore, deadLetterSink
+	providerStore = ProviderStore{"relay": {Name: "relay", forwarder: f}}
+	sink := &recordingDeadLetterSink{}
+	deadLetterSink = sink
+	defer func() { providerStore, deadLetterSink = prevStore, prevSink }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, topic, sub := newTestSubscription(t, ctx)
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := topic.Publish(ctx, &pubsub.Message{
+			Data:       []byte(`{"id":` + id + `}`),
+			Attributes: map[string]string{"provider": "relay", "app_id": "app-" + id, "event_type": "charge.success"},
+		}).Get(ctx)
+		require.NoError(t, err)
+	}
+
+	// Act
+	done := make(chan error)
+	go func() {
+		done <- RunWorker(ctx, sub, &WorkerConfig{Concurrency: 1, BatchSize: 3, BatchWait: time.Second})
+	}()
+
+	require.Eventually(t, func() bool {
+		for _, m := range srv.Messages() {
+			if m.Acks == 0 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// Assert
+	require.Equal(t, 1, batches)
+	require.Equal(t, 3, singles)
+	require.Len(t, sink.letters, 1)
+	require.Equal(t, "app-2", sink.letters[0].Attributes["app_id"])
+}
+
+func Test_RunWorker_HoldsPausedMessages(t *testing.T) {
+	// Arrange
+	var mu sync.Mutex
+	calls := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first call opens the circuit; the probe after the cooldown
+		// succeeds.
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	f, err := newHTTPForwarder(&HTTPTargetConfig{URL: target.URL})
+	require.NoError(t, err)
+
+	prevStore, prevAPI, prevSleep := providerStore, convoyAPI, sleep
+	providerStore = ProviderStore{"relay": {Name: "relay", forwarder: f}}
+	convoyAPI = newConvoyClient(&ConvoyConfig{BreakerThreshold: 1, BreakerCooldown: 300 * time.Millisecond})
+	sleep = func(context.Context, time.Duration) error { return nil }
+	defer func() { providerStore, convoyAPI, sleep = prevStore, prevAPI, prevSleep }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, topic, sub := newTestSubscription(t, ctx)
+	_, err = topic.Publish(ctx, &pubsub.Message{
+		Data:       []byte(`{"id":302961}`),
+		Attributes: map[string]string{"provider": "relay", "app_id": "app-1", "event_type": "charge.success"},
+	}).Get(ctx)
+	require.NoError(t, err)
+
+	// Act
+	done := make(chan error)
+	go func() {
+		done <- RunWorker(ctx, sub, &WorkerConfig{Concurrency: 1, BatchSize: 1, BatchWait: time.Millisecond})
+	}()
+
+	require.Eventually(t, func() bool {
+		return srv.Messages()[0].Acks != 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// Assert: the message was held through the cooldown, not redelivered
+	// while the circuit was open.
+	require.Equal(t, 2, srv.Messages()[0].Deliveries)
+	require.Equal(t, 2, calls)
+}
+
+func Test_RunWorker_HoldsInFlightDuplicates(t *testing.T) {
+	// Arrange
+	prevStore := dedupeStore
+	dedupeStore = newMemoryDedupeStore()
+	defer func() { dedupeStore = prevStore }()
+
+	_, err := dedupeStore.Reserve("push:app-1:relay:302961", inFlightIdempotencyTTL)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv, topic, sub := newTestSubscription(t, ctx)
+	_, err = topic.Publish(ctx, &pubsub.Message{
+		Data: []byte(`{"id":302961}`),
+		Attributes: map[string]string{
+			"provider":        "relay",
+			"app_id":          "app-1",
+			"event_type":      "charge.success",
+			"idempotency_key": "relay:302961",
+		},
+	}).Get(ctx)
+	require.NoError(t, err)
+
+	// Act
+	done := make(chan error)
+	go func() {
+		done <- RunWorker(ctx, sub, &WorkerConfig{Concurrency: 1, BatchSize: 1, BatchWait: time.Millisecond})
+	}()
+
+	time.Sleep(time.Second)
+	cancel()
+	require.NoError(t, <-done)
+
+	// Assert: the copy was held rather than nacked and redelivered at once.
+	require.Equal(t, 1, srv.Messages()[0].Deliveries)
+	require.Zero(t, srv.Messages()[0].Acks)
+}
+
+// newTestSubscription returns a topic and subscription on a fake Pub/Sub
+// server.
+func newTestSubscription(t *testing.T, ctx context.Context, opts ...pstest.ServerReactorOption) (*pstest.Server, *pubsub.Topic, *pubsub.Subscription) {
+	srv := pstest.NewServer(opts...)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := grpc.Dial(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	client, err := pubsub.NewClient(ctx, "project", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+
+	topic, err := client.CreateTopic(ctx, "events")
+	require.NoError(t, err)
+
+	sub, err := client.CreateSubscription(ctx, "worker", pubsub.SubscriptionConfig{Topic: topic})
+	require.NoError(t, err)
+
+	return srv, topic, sub
+}